@@ -1,12 +1,14 @@
 package main
 
 import (
-	"bytes"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
+	"BM2/protocol"
+
 	"go.bug.st/serial"
 )
 
@@ -23,6 +25,21 @@ func addChecksum(data []byte) []byte {
 	return append(data, byte(sum&0xff))
 }
 
+// sleepCtx 可被取消的 Sleep；取消時回傳 ctx.Err()
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // encodeAudioData 執行 +0x80 的音訊數據編碼 (Dart Protocol 關鍵邏輯)
 func encodeAudioData(rawData []byte) []byte {
 	var audioData []byte
@@ -45,104 +62,238 @@ func encodeAudioData(rawData []byte) []byte {
 	return audioData
 }
 
+// decodeAudioData 還原 encodeAudioData 的 +0x80 (例如從安全帽讀回的映像)；
+// 604/605 不會被改寫，Checksum 仍以映像中的值為準
+func decodeAudioData(encoded []byte) []byte {
+	raw := make([]byte, len(encoded))
+	copy(raw, encoded)
+	for i := 607; i < len(raw); i += 2 {
+		raw[i] -= 0x80
+	}
+	return raw
+}
+
 // ==========================================
 // 2. 通訊介面與實作 (原 transport.go 邏輯)
 // ==========================================
 
 type Transporter interface {
-	Connect(mac string) error
+	Connect(ctx context.Context, mac string) error
 	Disconnect() error
-	SendCmd(target byte, fid *uint16, payload []byte) error
-	SendAudioChunk(fid *uint16, offset int, data []byte) error
-	ReadResponse(timeout time.Duration) ([]byte, error)
+	SendCmd(target byte, payload []byte) (uint16, error)
+	SendAudioChunk(offset int, data []byte) (uint16, error)
+	ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error)
 	ResetBuffer()
-	WaitForACK(timeout time.Duration) error
+	WaitForACK(ctx context.Context, fid uint16, timeout time.Duration) error
+}
+
+// SendRequest 將 protocol 指令送往其對應的 Target
+func SendRequest(t Transporter, req protocol.Request) (uint16, error) {
+	return t.SendCmd(req.Target(), req.Encode())
 }
 
 type SerialAdaptor struct {
 	PortName    string
 	Port        serial.Port
 	internalFid uint16
+	decoder     FrameDecoder
+	tap         func(dir string, data []byte)
+}
+
+// SetTap 設定原始收發的監聽函式 (Trace 錄製用)，包含 Connect 過程與無法解析的位元組
+func (s *SerialAdaptor) SetTap(tap func(dir string, data []byte)) {
+	s.tap = tap
 }
 
 func NewSerialAdaptor(portName string) *SerialAdaptor {
 	return &SerialAdaptor{PortName: portName}
 }
 
-// Connect: 回歸原始邏輯，僅增加等待時間
-func (s *SerialAdaptor) Connect(mac string) error {
-	mode := &serial.Mode{BaudRate: 115200}
-	port, err := serial.Open(s.PortName, mode)
+var (
+	// ErrConnectRejected Dongle 以 NACK 拒絕 0x85 連線指令
+	ErrConnectRejected = errors.New("connect rejected")
+	// ErrDongleFault Dongle 端的故障 (Port 無法開啟、讀寫失敗)，與安全帽無關；只有這類失敗計入 Dongle 健康度
+	ErrDongleFault = errors.New("dongle fault")
+)
+
+// legacyDongles 最近判定為不回 ACK 的舊版 Dongle (key: Port 名稱，value: 判定時間)
+// 判定超過 legacyDongleTTL 即失效，開機較慢的新版 Dongle 不會一直被當成舊版
+var legacyDongles sync.Map
+
+const (
+	legacyDongleTTL = 10 * time.Minute
+
+	// connectSends 每個階段最多送出幾次；沒有 ACK 就視為舊版 Dongle
+	connectSends = 2
+)
+
+// isLegacyDongle 該 Port 最近是否判定為舊版 Dongle；過期的判定會被清除
+func isLegacyDongle(port string) bool {
+	v, ok := legacyDongles.Load(port)
+	if !ok {
+		return false
+	}
+	if time.Since(v.(time.Time)) > legacyDongleTTL {
+		legacyDongles.CompareAndDelete(port, v)
+		return false
+	}
+	return true
+}
+
+// Connect 新版 Dongle 以 ACK 推進 Stop Scan 與模式切換；舊版 Dongle 維持原本的固定等待。
+// 韌體沒有回報連線建立的訊息 (0x85 的 ACK 只代表收到指令)，因此 0x85 後仍等滿 6 秒，
+// 期間 Dongle 以 NACK 拒絕則立即回傳 ErrConnectRejected。取消時關閉 Port 並回傳 ctx.Err()
+func (s *SerialAdaptor) Connect(ctx context.Context, mac string) error {
+	macBytes, err := protocol.ParseMAC(mac)
 	if err != nil {
 		return err
 	}
+
+	mode := &serial.Mode{BaudRate: 115200}
+	port, err := openPort(s.PortName, mode)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDongleFault, err)
+	}
 	s.Port = port
 	s.internalFid = 0
+	legacy := isLegacyDongle(s.PortName)
+
+	fail := func(step string, err error) error {
+		s.Disconnect()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("%s: %w", step, err)
+	}
 
 	// 1. Reset 1
 	s.toggleDTR_RTS(100 * time.Millisecond)
-	time.Sleep(2 * time.Second)
 	s.ResetBuffer()
 
-	// 2. Stop Scan
-	s.SendCmd(0x24, nil, []byte{0x83, 0x00})
-	time.Sleep(200 * time.Millisecond)
+	// 2. Stop Scan：新版 Dongle 開機完成後會 ACK；舊版沿用原本的 2 秒開機等待
+	if legacy {
+		if err := sleepCtx(ctx, 2*time.Second); err != nil {
+			return fail("stop scan", err)
+		}
+		s.ResetBuffer()
+		fid, err := SendRequest(s, protocol.StopScan{})
+		if err != nil {
+			return fail("stop scan", err)
+		}
+		// 原本的 200ms 等待改為等 ACK：有回應就不是舊版，下次連線改走 ACK 流程
+		if s.WaitForACK(ctx, fid, 200*time.Millisecond) == nil {
+			legacyDongles.Delete(s.PortName)
+		}
+	} else {
+		acked, err := s.sendUntilACK(ctx, protocol.StopScan{}, connectSends, time.Second)
+		if err != nil {
+			return fail("stop scan", err)
+		}
+		if !acked && ctx.Err() == nil {
+			// 已等滿開機時間仍無 ACK：補送一次，本次 (及判定有效期間) 改走固定等待
+			legacy = true
+			legacyDongles.Store(s.PortName, time.Now())
+			sendLog(s.PortName, "ℹ️ Dongle 未回應 ACK，改用固定等待")
+			if _, err := SendRequest(s, protocol.StopScan{}); err != nil {
+				return fail("stop scan", err)
+			}
+			sleepCtx(ctx, 200*time.Millisecond)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fail("stop scan", err)
+	}
 
 	// 3. Connect (0x85)
-	cleanMac := strings.ReplaceAll(strings.TrimSpace(mac), ":", "")
-	macBytes, err := hex.DecodeString(cleanMac)
+	fid, err := SendRequest(s, protocol.Connect{MAC: macBytes})
 	if err != nil {
-		return fmt.Errorf("invalid mac: %v", err)
+		return fail("connect", err)
 	}
-
-	connPayload := []byte{0x85}
-	for i := len(macBytes) - 1; i >= 0; i-- {
-		connPayload = append(connPayload, macBytes[i])
+	if err := s.awaitConnectReject(ctx, fid, 6*time.Second); err != nil {
+		return fail("connect", err)
 	}
-	s.SendCmd(0x24, nil, connPayload)
-
-	time.Sleep(6 * time.Second)
 
 	// 4. Reset 2 (Switch Mode)
 	s.toggleDTR_RTS(100 * time.Millisecond)
-	time.Sleep(1 * time.Second)
 
 	// 5. Magic Command (0x21)
-	s.SendCmd(0x21, nil, []byte{0x01})
-	time.Sleep(1 * time.Second)
+	if legacy {
+		if err := sleepCtx(ctx, time.Second); err != nil {
+			return fail("mode switch", err)
+		}
+		if _, err := SendRequest(s, protocol.ModeSwitch{Mode: protocol.ModePassthrough}); err != nil {
+			return fail("mode switch", err)
+		}
+		sleepCtx(ctx, time.Second)
+	} else if _, err := s.sendUntilACK(ctx, protocol.ModeSwitch{Mode: protocol.ModePassthrough}, connectSends, time.Second); err != nil {
+		return fail("mode switch", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return fail("mode switch", err)
+	}
 
 	return nil
 }
 
-// SendAudioChunk 保持原本邏輯
-func (s *SerialAdaptor) SendAudioChunk(_ *uint16, offset int, data []byte) error {
-	payload := make([]byte, 0, 1+4+2+len(data))
-	payload = append(payload, 0xC5)
-	payload = append(payload, byte(offset&0xff), byte((offset>>8)&0xff), byte((offset>>16)&0xff), byte((offset>>24)&0xff))
-	dLen := len(data)
-	payload = append(payload, byte(dLen&0xff), byte((dLen>>8)&0xff))
-	payload = append(payload, data...)
-	return s.SendCmd(0x20, nil, payload)
+// awaitConnectReject 等待 0x85 之後的固定時間；收到該指令的 NACK 時回傳 ErrConnectRejected
+func (s *SerialAdaptor) awaitConnectReject(ctx context.Context, fid uint16, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		f, err := s.ReadFrame(ctx, remaining)
+		if errors.Is(err, ErrACKTimeout) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if f.FID == fid && f.Kind == FrameNACK {
+			return fmt.Errorf("%w (status 0x%04X)", ErrConnectRejected, f.Status)
+		}
+	}
 }
 
-// SendCmd 修改：呼叫外部 addChecksum 減少重複邏輯
-func (s *SerialAdaptor) SendCmd(target byte, _ *uint16, payload []byte) error {
+// sendUntilACK 送出指令並等待 ACK，最多送 sends 次、每次等待 each；寫入失敗時回傳錯誤
+func (s *SerialAdaptor) sendUntilACK(ctx context.Context, req protocol.Request, sends int, each time.Duration) (bool, error) {
+	for i := 0; i < sends && ctx.Err() == nil; i++ {
+		fid, err := SendRequest(s, req)
+		if err != nil {
+			return false, err
+		}
+		if s.WaitForACK(ctx, fid, each) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SendAudioChunk 寫入一段音訊，回傳該封包使用的 Frame ID
+func (s *SerialAdaptor) SendAudioChunk(offset int, data []byte) (uint16, error) {
+	return SendRequest(s, protocol.WriteAudio{Offset: uint32(offset), Data: data})
+}
+
+// SendCmd 送出指令並回傳本次使用的 Frame ID，供 WaitForACK 比對
+func (s *SerialAdaptor) SendCmd(target byte, payload []byte) (uint16, error) {
 	if s.Port == nil {
-		return fmt.Errorf("port closed")
+		return 0, fmt.Errorf("port closed")
 	}
 	s.internalFid++
 	f := s.internalFid
-	plLen := len(payload)
 
-	// 建立封包
-	packet := []byte{0x25, target, byte(f & 0xff), byte((f >> 8) & 0xff), 0x00, 0x00, byte(plLen & 0xff), byte((plLen >> 8) & 0xff)}
-	packet = append(packet, payload...)
+	// 建立封包 (含 Checksum)
+	packet := buildFrame(target, f, 0, payload)
 
-	// 🔥 這裡改用整合後的函式
-	packet = addChecksum(packet)
-
-	_, err := s.Port.Write(packet)
-	return err
+	n, err := s.Port.Write(packet)
+	if s.tap != nil && n > 0 {
+		s.tap(TraceTX, packet[:n])
+	}
+	if err != nil {
+		return f, fmt.Errorf("%w: %w", ErrDongleFault, err)
+	}
+	return f, nil
 }
 
 func (s *SerialAdaptor) toggleDTR_RTS(sleepTime time.Duration) {
@@ -169,44 +320,79 @@ func (s *SerialAdaptor) ResetBuffer() {
 		s.Port.ResetInputBuffer()
 		s.Port.ResetOutputBuffer()
 	}
+	s.decoder.Reset()
 }
 
-func (s *SerialAdaptor) WaitForACK(timeout time.Duration) error {
+// WaitForACK 等待指定 Frame ID 的回應
+func (s *SerialAdaptor) WaitForACK(ctx context.Context, fid uint16, timeout time.Duration) error {
+	return waitForACK(ctx, s, fid, timeout)
+}
+
+// ReadFrame 讀取下一個完整封包；每次最多阻塞 50ms，以便及時回應取消
+func (s *SerialAdaptor) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
 	if s.Port == nil {
-		return fmt.Errorf("port closed")
+		return Frame{}, fmt.Errorf("port closed")
 	}
-	buffer := make([]byte, 0, 256)
-	temp := make([]byte, 64)
+	temp := make([]byte, 256)
 	deadline := time.Now().Add(timeout)
 
-	for time.Now().Before(deadline) {
-		s.Port.SetReadTimeout(50 * time.Millisecond)
-		n, _ := s.Port.Read(temp)
+	for {
+		if f, ok := s.decoder.Next(); ok {
+			return f, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return Frame{}, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return Frame{}, ErrACKTimeout
+		}
+		if remaining > 50*time.Millisecond {
+			remaining = 50 * time.Millisecond
+		}
+		s.Port.SetReadTimeout(remaining)
+		n, err := s.Port.Read(temp)
+		if err != nil {
+			return Frame{}, fmt.Errorf("%w: %w", ErrDongleFault, err)
+		}
 		if n > 0 {
-			buffer = append(buffer, temp[:n]...)
-			if bytes.IndexByte(buffer, 0x27) != -1 ||
-				bytes.IndexByte(buffer, 0x25) != -1 ||
-				bytes.IndexByte(buffer, 0x26) != -1 ||
-				bytes.IndexByte(buffer, 0x23) != -1 {
-				return nil
-			}
-			if len(buffer) > 200 {
-				buffer = buffer[len(buffer)-50:]
+			if s.tap != nil {
+				s.tap(TraceRX, temp[:n])
 			}
+			s.decoder.Feed(temp[:n])
 		}
 	}
-	return fmt.Errorf("timeout")
 }
 
-func (s *SerialAdaptor) ReadResponse(timeout time.Duration) ([]byte, error) {
-	if s.Port == nil {
-		return nil, fmt.Errorf("port closed")
+// Probe 不連線安全帽的自我測試 (隔離中使用)：新版 Dongle 須回應 Stop Scan 的 ACK；
+// 舊版 Dongle 從不回 ACK，Port 能開啟並寫入即視為通過
+func (s *SerialAdaptor) Probe(ctx context.Context) error {
+	port, err := openPort(s.PortName, &serial.Mode{BaudRate: 115200})
+	if err != nil {
+		return err
+	}
+	s.Port = port
+	s.internalFid = 0
+	defer s.Disconnect()
+
+	s.toggleDTR_RTS(100 * time.Millisecond)
+	s.ResetBuffer()
+	if isLegacyDongle(s.PortName) {
+		if err := sleepCtx(ctx, 2*time.Second); err != nil {
+			return err
+		}
+		_, err := SendRequest(s, protocol.StopScan{})
+		return err
 	}
-	buf := make([]byte, 4096)
-	s.Port.SetReadTimeout(timeout)
-	n, err := s.Port.Read(buf)
+	acked, err := s.sendUntilACK(ctx, protocol.StopScan{}, connectSends, time.Second)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return buf[:n], nil
+	if !acked {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("no ACK for stop scan")
+	}
+	return nil
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// ==========================================
+// ADS 映像產生 (與 Dart AdsEncoder.convertToAds 位元組一致)
+// Header 606 bytes: Magic 27 9D | 軌道數 (uint16) | 50 × [ID, Offset, Size] | Checksum (604)
+// ==========================================
+
+const (
+	adsHeaderSize  = 606
+	adsMaxTracks   = 50
+	adsChecksumOff = 604
+	wavHeaderSize  = 44
+)
+
+// ADSTrack 一個音軌；PCM 為 nil 時寫入空項目 (Offset 606, Size 0)，與 Dart 下載失敗時相同
+type ADSTrack struct {
+	ID  uint32
+	PCM []byte
+}
+
+// BuildADS 依 Track ID 排序後組出完整 ADS 映像
+func BuildADS(tracks []ADSTrack) ([]byte, error) {
+	if len(tracks) > adsMaxTracks {
+		return nil, fmt.Errorf("too many tracks: %d (max %d)", len(tracks), adsMaxTracks)
+	}
+	sorted := append([]ADSTrack(nil), tracks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	header := make([]byte, adsHeaderSize)
+	header[0], header[1] = 0x27, 0x9D
+	binary.LittleEndian.PutUint16(header[2:4], uint16(len(sorted)))
+
+	offset := adsHeaderSize
+	for i, tr := range sorted {
+		if i > 0 && tr.ID == sorted[i-1].ID {
+			return nil, fmt.Errorf("duplicate track id %d", tr.ID)
+		}
+		entry := 4 + i*12
+		binary.LittleEndian.PutUint32(header[entry:], tr.ID)
+		if tr.PCM == nil {
+			binary.LittleEndian.PutUint32(header[entry+4:], adsHeaderSize)
+			continue
+		}
+		binary.LittleEndian.PutUint32(header[entry+4:], uint32(offset))
+		binary.LittleEndian.PutUint32(header[entry+8:], uint32(len(tr.PCM)))
+		offset += len(tr.PCM)
+	}
+	binary.LittleEndian.PutUint16(header[adsChecksumOff:], adsHeaderChecksum(header))
+
+	image := make([]byte, 0, offset)
+	image = append(image, header...)
+	for _, tr := range sorted {
+		image = append(image, tr.PCM...)
+	}
+	return image, nil
+}
+
+// adsTrackCount Header 記錄的軌道數 (Byte 2~3, uint16 LE)；解析、驗證、備份都以此為準
+func adsTrackCount(header []byte) int {
+	return int(binary.LittleEndian.Uint16(header[2:4]))
+}
+
+// adsHeaderChecksum Byte 0~603 的總和 (uint16)
+func adsHeaderChecksum(image []byte) uint16 {
+	sum := 0
+	for _, b := range image[:adsChecksumOff] {
+		sum += int(b)
+	}
+	return uint16(sum & 0xFFFF)
+}
+
+// LoadADSManifest 讀取 {"<Track ID>": "<WAV 路徑>"} 格式的 JSON；相對路徑以 Manifest 所在目錄為準
+// 每個 WAV 都會經過 IngestWAV，有任何音軌被拒絕時仍回傳完整報告
+func LoadADSManifest(path string, opts AudioOptions) ([]ADSTrack, []TrackReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries map[string]string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, nil, fmt.Errorf("manifest: %v", err)
+	}
+
+	dir := filepath.Dir(path)
+	var tracks []ADSTrack
+	var reports []TrackReport
+	rejected := 0
+	for key, file := range entries {
+		id, err := strconv.ParseUint(key, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("manifest: invalid track id %q", key)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		raw, err := os.ReadFile(file)
+		var pcm []byte
+		var report TrackReport
+		if err == nil {
+			pcm, report, err = IngestWAV(raw, opts)
+		}
+		report.ID, report.File = uint32(id), file
+		if err != nil {
+			report.Error = err.Error()
+			rejected++
+		} else {
+			tracks = append(tracks, ADSTrack{ID: uint32(id), PCM: pcm})
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	if rejected > 0 {
+		return nil, reports, fmt.Errorf("%d track(s) rejected", rejected)
+	}
+	return tracks, reports, nil
+}
+
+// BuildADSFromManifest 讀取 Manifest 與 WAV 檔並產生 ADS 映像與每軌報告
+func BuildADSFromManifest(path string, opts AudioOptions) ([]byte, []TrackReport, error) {
+	tracks, reports, err := LoadADSManifest(path, opts)
+	if err != nil {
+		return nil, reports, err
+	}
+	image, err := BuildADS(tracks)
+	return image, reports, err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/dart_parity/expected.ads 依 lib/utils/ads_encoder.dart (AdsEncoder.convertToAds)
+// 逐步轉寫產生：輸入為同目錄的 WAV (Track 3、5、12)，Track 40 模擬下載失敗 (_writeEmptyEntry)。
+// Dart 端：依 ID 排序、跳過 44 bytes WAV 檔頭、Checksum 為 Byte 0~603 總和
+func TestBuildADSMatchesDartEncoder(t *testing.T) {
+	dir := filepath.Join("testdata", "dart_parity")
+	want, err := os.ReadFile(filepath.Join(dir, "expected.ads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 刻意不依 ID 順序，確認排序與 Dart 相同
+	tracks := []ADSTrack{{ID: 40}}
+	for _, id := range []uint32{12, 3, 5} {
+		raw, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.wav", id)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pcm, _, err := IngestWAV(raw, AudioOptions{})
+		if err != nil {
+			t.Fatalf("track %d: %v", id, err)
+		}
+		tracks = append(tracks, ADSTrack{ID: id, PCM: pcm})
+	}
+
+	got, err := BuildADS(tracks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		for i := 0; i < len(got) && i < len(want); i++ {
+			if got[i] != want[i] {
+				t.Fatalf("first difference at byte %d: got %02x, want %02x (len %d vs %d)", i, got[i], want[i], len(got), len(want))
+			}
+		}
+		t.Fatalf("length %d, want %d", len(got), len(want))
+	}
+}
+
+// 內建示範映像與先前錄製 Trace 時使用的相同，且通過嚴格驗證
+func TestBuiltinCLIImage(t *testing.T) {
+	meta, err := loadCLIImage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(meta.RawData, syntheticADS(1, 4096, 2, 3000, 3, 1536)) {
+		t.Error("builtin image changed; existing replay traces would diverge")
+	}
+	if issues := ValidateADS(meta.RawData); len(issues) != 0 {
+		t.Errorf("builtin image issues: %v", issues)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ==========================================
+// ADS 逐軌比對 (變更審核用)
+// 以 Track ID 對齊，內容以各音軌 PCM 的 SHA-256 判斷
+// ==========================================
+
+const (
+	TrackAdded   = "ADDED"
+	TrackRemoved = "REMOVED"
+	TrackResized = "RESIZED"
+	TrackChanged = "CHANGED" // 大小相同但內容不同
+	TrackInvalid = "INVALID" // 任一方的 Offset/Size 超出檔案範圍，無法比對內容
+)
+
+// TrackDiff 單一 Track ID 的差異
+type TrackDiff struct {
+	ID      uint32 `json:"id"`
+	Change  string `json:"change"`
+	OldSize uint32 `json:"old_size,omitempty"`
+	NewSize uint32 `json:"new_size,omitempty"`
+	OldHash string `json:"old_sha256,omitempty"`
+	NewHash string `json:"new_sha256,omitempty"`
+}
+
+// ADSDiff 兩個 ADS 的比對結果
+type ADSDiff struct {
+	Old       string      `json:"old"`
+	New       string      `json:"new"`
+	Changes   []TrackDiff `json:"changes"`
+	Unchanged int         `json:"unchanged"`
+}
+
+type trackDigest struct {
+	info    TrackInfo
+	hash    string
+	invalid bool // 資料超出檔案範圍
+}
+
+// trackDigests 以 Track ID 為 key 計算每個音軌的雜湊；重複 ID 以表格中第一個為準
+func trackDigests(meta FileMeta) map[uint32]trackDigest {
+	digests := make(map[uint32]trackDigest)
+	for no := 1; no <= len(meta.Tracks); no++ {
+		info, ok := meta.Tracks[no]
+		if !ok {
+			continue
+		}
+		if _, dup := digests[info.ID]; dup {
+			continue
+		}
+		d := trackDigest{info: info}
+		start, end := int(info.Offset), int(info.Offset)+int(info.Size)
+		switch {
+		case info.Size == 0:
+		case end > len(meta.RawData):
+			d.invalid = true
+		default:
+			sum := sha256.Sum256(meta.RawData[start:end])
+			d.hash = hex.EncodeToString(sum[:])
+		}
+		digests[info.ID] = d
+	}
+	return digests
+}
+
+// DiffADS 比對兩份已解析的 ADS
+func DiffADS(oldName string, oldMeta FileMeta, newName string, newMeta FileMeta) ADSDiff {
+	oldTracks, newTracks := trackDigests(oldMeta), trackDigests(newMeta)
+	diff := ADSDiff{Old: oldName, New: newName, Changes: []TrackDiff{}}
+
+	for id, o := range oldTracks {
+		n, ok := newTracks[id]
+		switch {
+		case !ok:
+			diff.Changes = append(diff.Changes, TrackDiff{ID: id, Change: TrackRemoved, OldSize: o.info.Size, OldHash: o.hash})
+		case o.invalid || n.invalid:
+			diff.Changes = append(diff.Changes, TrackDiff{ID: id, Change: TrackInvalid, OldSize: o.info.Size, NewSize: n.info.Size, OldHash: o.hash, NewHash: n.hash})
+		case o.info.Size != n.info.Size:
+			diff.Changes = append(diff.Changes, TrackDiff{ID: id, Change: TrackResized, OldSize: o.info.Size, NewSize: n.info.Size, OldHash: o.hash, NewHash: n.hash})
+		case o.hash != n.hash:
+			diff.Changes = append(diff.Changes, TrackDiff{ID: id, Change: TrackChanged, OldSize: o.info.Size, NewSize: n.info.Size, OldHash: o.hash, NewHash: n.hash})
+		default:
+			diff.Unchanged++
+		}
+	}
+	for id, n := range newTracks {
+		if _, ok := oldTracks[id]; !ok {
+			diff.Changes = append(diff.Changes, TrackDiff{ID: id, Change: TrackAdded, NewSize: n.info.Size, NewHash: n.hash})
+		}
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool { return diff.Changes[i].ID < diff.Changes[j].ID })
+	return diff
+}
+
+// WriteText 輸出給人看的報告
+func (d ADSDiff) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", d.Old, d.New)
+	for _, c := range d.Changes {
+		switch c.Change {
+		case TrackAdded:
+			fmt.Fprintf(w, "+ %-6d %-8s %d bytes  %s\n", c.ID, c.Change, c.NewSize, shortHash(c.NewHash))
+		case TrackRemoved:
+			fmt.Fprintf(w, "- %-6d %-8s %d bytes  %s\n", c.ID, c.Change, c.OldSize, shortHash(c.OldHash))
+		case TrackInvalid:
+			fmt.Fprintf(w, "! %-6d %-8s %d -> %d bytes  (超出檔案範圍)\n", c.ID, c.Change, c.OldSize, c.NewSize)
+		default:
+			fmt.Fprintf(w, "~ %-6d %-8s %d -> %d bytes  %s -> %s\n", c.ID, c.Change, c.OldSize, c.NewSize, shortHash(c.OldHash), shortHash(c.NewHash))
+		}
+	}
+	fmt.Fprintf(w, "%d changed, %d unchanged\n", len(d.Changes), d.Unchanged)
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	if h == "" {
+		return "-"
+	}
+	return h
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestDiffADS(t *testing.T) {
+	base := syntheticADS(1, 1000, 2, 2000, 3, 500)
+	parse := func(image []byte) FileMeta {
+		meta := parseADSBytesTo(io.Discard, image)
+		if meta.RawData == nil {
+			t.Fatal("synthetic image did not parse")
+		}
+		return meta
+	}
+
+	changed := append([]byte(nil), base...)
+	changed[adsHeaderSize+1000+10] ^= 0xFF // Track 2 內容
+
+	tests := []struct {
+		name     string
+		old, new []byte
+		want     map[uint32]string
+	}{
+		{"identical", base, base, map[uint32]string{}},
+		{"content changed", base, changed, map[uint32]string{2: TrackChanged}},
+		{"removed", base, syntheticADS(1, 1000, 2, 2000), map[uint32]string{3: TrackRemoved}},
+		{"added and resized", syntheticADS(1, 1000), syntheticADS(1, 800, 4, 10), map[uint32]string{1: TrackResized, 4: TrackAdded}},
+		// 兩邊都被截斷：不能因為雜湊都是空的就當成沒變
+		{"truncated on both sides", base[:len(base)-100], base[:len(base)-100], map[uint32]string{3: TrackInvalid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffADS("old", parse(tt.old), "new", parse(tt.new))
+			got := make(map[uint32]string)
+			for _, c := range diff.Changes {
+				got[c.ID] = c.Change
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("changes = %v, want %v", got, tt.want)
+			}
+			for id, change := range tt.want {
+				if got[id] != change {
+					t.Errorf("track %d: %q, want %q", id, got[id], change)
+				}
+			}
+		})
+	}
+}
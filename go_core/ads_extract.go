@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ==========================================
+// ADS 音軌匯出為 WAV (燒錄前試聽用)
+// ==========================================
+
+// DefaultSampleRate 匯出試聽 WAV 時預設的取樣率。ADS 與通訊協定都沒有記錄取樣率，
+// 16kHz 尚未以韌體規格或實機確認，因此 build-ads 不套用此值：需以 -rate 明確指定才會檢查或轉換
+const DefaultSampleRate = 16000
+
+// ExtractedTrack 從 ADS 切出的單一音軌
+type ExtractedTrack struct {
+	No  int // 表格序號 (1 起算)
+	ID  uint32
+	PCM []byte
+}
+
+// ExtractADSTracks 依 Header 表格切出每個音軌的 PCM；
+// encoded 為 true 代表映像是從安全帽讀回的 (已 +0x80)，會先還原
+func ExtractADSTracks(image []byte, encoded bool) ([]ExtractedTrack, error) {
+	if len(image) < adsHeaderSize || image[0] != 0x27 || image[1] != 0x9D {
+		return nil, fmt.Errorf("not an ADS image (missing header)")
+	}
+	if encoded {
+		image = decodeAudioData(image)
+	}
+	// stdout 只輸出寫出的檔案路徑，Header 表格改寫到 stderr
+	_, table := parseHeaderTo(os.Stderr, image[:adsHeaderSize], "Extract", "[FILE]")
+
+	var tracks []ExtractedTrack
+	for no, info := range table {
+		start, end := int(info.Offset), int(info.Offset)+int(info.Size)
+		if info.Size == 0 {
+			continue
+		}
+		if start < adsHeaderSize || end > len(image) {
+			return nil, fmt.Errorf("track %d (id %d) spans %d..%d beyond image size %d", no, info.ID, start, end, len(image))
+		}
+		tracks = append(tracks, ExtractedTrack{No: no, ID: info.ID, PCM: image[start:end]})
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].No < tracks[j].No })
+	return tracks, nil
+}
+
+// encodeWAV 包上 44 bytes 的 RIFF 檔頭 (16-bit PCM, 單聲道)；
+// 奇數長度補一個 0x00，湊滿最後一個樣本 (RIFF chunk 也須為偶數長度)
+func encodeWAV(pcm []byte, sampleRate int) []byte {
+	const channels, bits = 1, 16
+	if len(pcm)%2 == 1 {
+		pcm = append(pcm[:len(pcm):len(pcm)], 0)
+	}
+	blockAlign := channels * bits / 8
+	out := make([]byte, wavHeaderSize, wavHeaderSize+len(pcm))
+	copy(out[0:], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(36+len(pcm)))
+	copy(out[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(out[16:], 16)
+	binary.LittleEndian.PutUint16(out[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(out[22:], channels)
+	binary.LittleEndian.PutUint32(out[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(out[28:], uint32(sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(out[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(out[34:], bits)
+	copy(out[36:], "data")
+	binary.LittleEndian.PutUint32(out[40:], uint32(len(pcm)))
+	return append(out, pcm...)
+}
+
+// WriteTrackWAVs 將每個音軌寫成 <dir>/<Track ID>.wav，回傳寫出的檔案；
+// 同一個 Track ID 重複出現時，第二個起改寫成 <Track ID>-<序號>.wav，不會互相覆蓋
+func WriteTrackWAVs(tracks []ExtractedTrack, dir string, sampleRate int) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	seen := make(map[uint32]bool)
+	for _, tr := range tracks {
+		name := fmt.Sprintf("%d.wav", tr.ID)
+		if seen[tr.ID] {
+			name = fmt.Sprintf("%d-%d.wav", tr.ID, tr.No)
+		}
+		seen[tr.ID] = true
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, encodeWAV(tr.PCM, sampleRate), 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
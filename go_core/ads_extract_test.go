@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// captureStdout 回傳 fn 執行期間寫到 stdout 的內容
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- b
+	}()
+	fn()
+	w.Close()
+	return string(<-out)
+}
+
+func TestExtractADSTracks(t *testing.T) {
+	image := syntheticADS(7, 1000, 3, 2001)
+	want := map[uint32][]byte{
+		3: image[adsHeaderSize : adsHeaderSize+2001],
+		7: image[adsHeaderSize+2001:],
+	}
+
+	for _, encoded := range []bool{false, true} {
+		in := image
+		if encoded {
+			in = encodeAudioData(image)
+		}
+		var tracks []ExtractedTrack
+		var err error
+		// stdout 保留給檔案路徑，Header 表格不能寫到這裡
+		if out := captureStdout(t, func() { tracks, err = ExtractADSTracks(in, encoded) }); out != "" {
+			t.Errorf("encoded=%v: header written to stdout: %q", encoded, out)
+		}
+		if err != nil {
+			t.Fatalf("encoded=%v: %v", encoded, err)
+		}
+		if len(tracks) != 2 || tracks[0].No != 1 || tracks[0].ID != 3 || tracks[1].ID != 7 {
+			t.Fatalf("encoded=%v: tracks %+v", encoded, tracks)
+		}
+		for _, tr := range tracks {
+			if !bytes.Equal(tr.PCM, want[tr.ID]) {
+				t.Errorf("encoded=%v: track %d PCM differs", encoded, tr.ID)
+			}
+		}
+	}
+
+	if _, err := ExtractADSTracks(image[:100], false); err == nil {
+		t.Error("short image accepted")
+	}
+	if _, err := ExtractADSTracks(image[:len(image)-10], false); err == nil {
+		t.Error("track beyond the end of the image accepted")
+	}
+}
+
+// 奇數長度的 PCM 補一個 0x00，最後一個樣本才完整
+func TestEncodeWAVPadsOddLength(t *testing.T) {
+	pcm := []byte{1, 2, 3}
+	wav := encodeWAV(pcm, DefaultSampleRate)
+	if len(wav) != wavHeaderSize+4 {
+		t.Fatalf("wav size %d, want %d", len(wav), wavHeaderSize+4)
+	}
+	if got := binary.LittleEndian.Uint32(wav[40:]); got != 4 {
+		t.Errorf("data chunk size %d, want 4", got)
+	}
+	if got := binary.LittleEndian.Uint32(wav[4:]); got != 36+4 {
+		t.Errorf("RIFF size %d, want 40", got)
+	}
+	if !bytes.Equal(wav[wavHeaderSize:], []byte{1, 2, 3, 0}) {
+		t.Errorf("data % X", wav[wavHeaderSize:])
+	}
+	if len(pcm) != 3 {
+		t.Error("caller's PCM was modified")
+	}
+}
+
+// 重複的 Track ID 不能互相覆蓋
+func TestWriteTrackWAVsDuplicateIDs(t *testing.T) {
+	image := syntheticADS(5, 100, 6, 200)
+	binary.LittleEndian.PutUint32(image[4+12:], 5) // 第二個項目改成相同的 ID
+	tracks, err := ExtractADSTracks(image, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	paths, err := WriteTrackWAVs(tracks, dir, DefaultSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "5.wav"), filepath.Join(dir, "5-2.wav")}
+	if len(paths) != 2 || paths[0] != want[0] || paths[1] != want[1] {
+		t.Fatalf("paths %v, want %v", paths, want)
+	}
+	for i, p := range paths {
+		wav, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wav[wavHeaderSize:], tracks[i].PCM) {
+			t.Errorf("%s does not hold track %d", p, tracks[i].No)
+		}
+	}
+}
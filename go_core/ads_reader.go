@@ -2,44 +2,72 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"text/tabwriter"
 )
 
 func ParseADSFile(path string) FileMeta {
-	fmt.Printf("🕵️‍♂️ 正在解析本地檔案: %s\n", path)
+	return ParseADSFileTo(os.Stdout, path)
+}
+
+// ParseADSFileTo 與 ParseADSFile 相同，解析過程的訊息改寫到 w
+func ParseADSFileTo(w io.Writer, path string) FileMeta {
+	fmt.Fprintf(w, "🕵️‍♂️ 正在解析本地檔案: %s\n", path)
 	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("❌ 無法開啟: %v\n", err)
+		fmt.Fprintf(w, "❌ 無法開啟: %v\n", err)
 		return FileMeta{}
 	}
+	return parseADSBytesTo(w, data)
+}
+
+// ParseADSBytes 解析已載入記憶體的 ADS 映像
+func ParseADSBytes(data []byte) FileMeta {
+	return parseADSBytesTo(os.Stdout, data)
+}
+
+func parseADSBytesTo(w io.Writer, data []byte) FileMeta {
 	magicCode := []byte{0x27, 0x9D}
 	headerIdx := bytes.Index(data, magicCode)
 	if headerIdx == -1 {
-		fmt.Printf("❌ 找不到 Magic Code\n")
+		fmt.Fprintf(w, "❌ 找不到 Magic Code\n")
+		return FileMeta{}
+	}
+	if len(data)-headerIdx < adsHeaderSize {
+		fmt.Fprintf(w, "❌ Header 不完整 (%d bytes)\n", len(data)-headerIdx)
 		return FileMeta{}
 	}
-	_, tracks := parseHeaderBytes(data[headerIdx:headerIdx+606], "Local ADS", "[FILE]")
+	_, tracks := parseHeaderTo(w, data[headerIdx:headerIdx+606], "Local ADS", "[FILE]")
 
 	// 🔥 關鍵修正：呼叫 utils.go 中的 encodeAudioData 進行轉碼
-	fmt.Println("🎼 正在執行音訊編碼轉換 (+0x80)...")
+	fmt.Fprintln(w, "🎼 正在執行音訊編碼轉換 (+0x80)...")
 	encoded := encodeAudioData(data)
 
+	sum := sha256.Sum256(data)
 	return FileMeta{
 		RawData:     data,
 		EncodedData: encoded, // ✅ 現在這裡是編碼過的正確資料
 		SizeKB:      len(data) / 1024,
 		Tracks:      tracks,
+		SHA256:      hex.EncodeToString(sum[:]),
 	}
 }
 
 func parseHeaderBytes(data []byte, label string, prefix string) (int, map[int]TrackInfo) {
-	trackCount := int(data[2])
-	fmt.Printf("%s 📊 [%s] 軌道數量: %d\n", prefix, label, trackCount)
+	return parseHeaderTo(os.Stdout, data, label, prefix)
+}
+
+// parseHeaderTo 解析 Header 的軌道表，表格輸出到 out
+func parseHeaderTo(out io.Writer, data []byte, label string, prefix string) (int, map[int]TrackInfo) {
+	trackCount := adsTrackCount(data)
+	fmt.Fprintf(out, "%s 📊 [%s] 軌道數量: %d\n", prefix, label, trackCount)
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
 	fmt.Fprintf(w, "%s No.\tTrack ID\tSize (Bytes)\n", prefix)
 
 	tracks := make(map[int]TrackInfo)
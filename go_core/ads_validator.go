@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ==========================================
+// ADS 嚴格驗證
+// ParseADSFile 只找 Magic 就開始解析；燒錄前先以這裡的檢查擋下壞檔，
+// 結果以結構化清單回報給 UI (ADS_REPORT 事件)
+// ==========================================
+
+const (
+	ADSError   = "error"
+	ADSWarning = "warning"
+)
+
+// 驗證項目代碼
+const (
+	ADSIssueTruncated   = "TRUNCATED"
+	ADSIssueMagic       = "MAGIC"
+	ADSIssueTrackCount  = "TRACK_COUNT"
+	ADSIssueOutOfBounds = "OUT_OF_BOUNDS"
+	ADSIssueOverlap     = "OVERLAP"
+	ADSIssueGap         = "GAP"
+	ADSIssueTrailing    = "TRAILING_DATA"
+	ADSIssueDuplicateID = "DUPLICATE_ID"
+	ADSIssueUnsorted    = "UNSORTED"
+	ADSIssueChecksum    = "CHECKSUM"
+	ADSIssueOddSize     = "ODD_SIZE"
+)
+
+// ADSIssue 單一驗證結果；Track 為表格中的序號 (1 起算)，0 代表整個檔案
+type ADSIssue struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Track    int    `json:"track,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (i ADSIssue) String() string {
+	if i.Track > 0 {
+		return fmt.Sprintf("%s %s (#%d): %s", i.Severity, i.Code, i.Track, i.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", i.Severity, i.Code, i.Message)
+}
+
+// hasADSErrors 是否有任何 error 等級的項目
+func hasADSErrors(issues []ADSIssue) bool {
+	for _, i := range issues {
+		if i.Severity == ADSError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateADSFile 讀取並驗證 ADS 檔案；只有讀檔失敗才回傳 error
+func ValidateADSFile(path string) ([]ADSIssue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateADS(data), nil
+}
+
+// ValidateADS 檢查 Header 與音軌表格；回傳空清單代表可以燒錄
+func ValidateADS(data []byte) []ADSIssue {
+	var issues []ADSIssue
+	add := func(severity, code string, track int, format string, a ...interface{}) {
+		issues = append(issues, ADSIssue{Severity: severity, Code: code, Track: track, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if len(data) < adsHeaderSize {
+		add(ADSError, ADSIssueTruncated, 0, "file is %d bytes, header needs %d", len(data), adsHeaderSize)
+		return issues
+	}
+	if data[0] != 0x27 || data[1] != 0x9D {
+		add(ADSError, ADSIssueMagic, 0, "magic at offset 0 is %02X %02X, want 27 9D", data[0], data[1])
+	}
+	if sum, stored := adsHeaderChecksum(data), binary.LittleEndian.Uint16(data[adsChecksumOff:]); sum != stored {
+		add(ADSError, ADSIssueChecksum, 0, "header checksum is 0x%04X, computed 0x%04X", stored, sum)
+	}
+
+	count := adsTrackCount(data)
+	if count > adsMaxTracks {
+		add(ADSError, ADSIssueTrackCount, 0, "track count %d exceeds the %d-entry table", count, adsMaxTracks)
+		count = adsMaxTracks
+	}
+	// 軌道數之後的項目不會被讀取；有內容代表軌道數寫錯
+	for i := count; i < adsMaxTracks; i++ {
+		entry := 4 + i*12
+		if binary.LittleEndian.Uint32(data[entry+8:]) != 0 {
+			add(ADSError, ADSIssueTrackCount, i+1, "entry #%d holds data but the header declares %d tracks", i+1, count)
+		}
+	}
+
+	type span struct {
+		track      int
+		start, end int
+	}
+	var spans []span
+	seen := make(map[uint32]int)
+	var prevID uint32
+	for i := 0; i < count; i++ {
+		no := i + 1
+		entry := 4 + i*12
+		id := binary.LittleEndian.Uint32(data[entry:])
+		offset := int(binary.LittleEndian.Uint32(data[entry+4:]))
+		size := int(binary.LittleEndian.Uint32(data[entry+8:]))
+
+		if first, ok := seen[id]; ok {
+			add(ADSError, ADSIssueDuplicateID, no, "track id %d already used by #%d", id, first)
+		} else {
+			seen[id] = no
+		}
+		if i > 0 && id < prevID {
+			add(ADSWarning, ADSIssueUnsorted, no, "track id %d follows %d", id, prevID)
+		}
+		prevID = id
+
+		if size == 0 {
+			// Dart 下載失敗時寫入的空項目
+			continue
+		}
+		if offset < adsHeaderSize || offset+size > len(data) {
+			add(ADSError, ADSIssueOutOfBounds, no, "track id %d spans %d..%d, file data is %d..%d", id, offset, offset+size, adsHeaderSize, len(data))
+			continue
+		}
+		if size%2 != 0 {
+			add(ADSWarning, ADSIssueOddSize, no, "track id %d has odd size %d (16-bit PCM)", id, size)
+		}
+		spans = append(spans, span{track: no, start: offset, end: offset + size})
+	}
+
+	// 依 Offset 排序後檢查重疊與空隙
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	pos := adsHeaderSize
+	for _, s := range spans {
+		switch {
+		case s.start < pos:
+			add(ADSError, ADSIssueOverlap, s.track, "data at %d overlaps previous track ending at %d", s.start, pos)
+		case s.start > pos:
+			add(ADSWarning, ADSIssueGap, s.track, "%d unused bytes before offset %d", s.start-pos, s.start)
+		}
+		if s.end > pos {
+			pos = s.end
+		}
+	}
+	if pos < len(data) {
+		add(ADSWarning, ADSIssueTrailing, 0, "%d bytes after the last track", len(data)-pos)
+	}
+	return issues
+}
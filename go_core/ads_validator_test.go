@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestValidateADS(t *testing.T) {
+	base := syntheticADS(1, 1000, 2, 2000, 3, 500)
+	entry := func(no int) int { return 4 + (no-1)*12 }
+	// patch 修改映像的副本並重算 Header Checksum
+	patch := func(image []byte, fn func(b []byte)) []byte {
+		b := append([]byte(nil), image...)
+		fn(b)
+		binary.LittleEndian.PutUint16(b[adsChecksumOff:], adsHeaderChecksum(b))
+		return b
+	}
+	badSum := append([]byte(nil), base...)
+	badSum[adsChecksumOff]++
+
+	tests := []struct {
+		name  string
+		image []byte
+		want  []string // "CODE#序號"，依字母排序
+	}{
+		{"valid", base, nil},
+		{"header truncated", base[:adsHeaderSize-1], []string{"TRUNCATED#0"}},
+		{"data truncated", base[:len(base)-100], []string{"OUT_OF_BOUNDS#3", "TRAILING_DATA#0"}},
+		{"bad magic", patch(base, func(b []byte) { b[1] = 0x9E }), []string{"MAGIC#0"}},
+		{"bad checksum", badSum, []string{"CHECKSUM#0"}},
+		{"offset inside header", patch(base, func(b []byte) {
+			binary.LittleEndian.PutUint32(b[entry(1)+4:], 100)
+		}), []string{"GAP#2", "OUT_OF_BOUNDS#1"}},
+		{"offset past end", patch(base, func(b []byte) {
+			binary.LittleEndian.PutUint32(b[entry(3)+4:], 0xFFFFFF00)
+		}), []string{"OUT_OF_BOUNDS#3", "TRAILING_DATA#0"}},
+		{"overlapping tracks", patch(base, func(b []byte) {
+			binary.LittleEndian.PutUint32(b[entry(2)+4:], adsHeaderSize+500)
+		}), []string{"GAP#3", "OVERLAP#2"}},
+		{"count below table", patch(base, func(b []byte) {
+			binary.LittleEndian.PutUint16(b[2:], 2)
+		}), []string{"TRACK_COUNT#3", "TRAILING_DATA#0"}},
+		{"duplicate id", patch(base, func(b []byte) {
+			binary.LittleEndian.PutUint32(b[entry(3):], 2)
+		}), []string{"DUPLICATE_ID#3"}},
+		{"odd size", syntheticADS(1, 1001), []string{"ODD_SIZE#1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, issue := range ValidateADS(tt.image) {
+				got = append(got, fmt.Sprintf("%s#%d", issue.Code, issue.Track))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("issues %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 軌道數為 uint16：高位元組不為 0 時，解析與驗證看到的是同一個數字
+func TestADSTrackCountIsUint16(t *testing.T) {
+	image := append([]byte(nil), syntheticADS(1, 1000, 2, 2000)...)
+	binary.LittleEndian.PutUint16(image[2:], 0x0102)
+	binary.LittleEndian.PutUint16(image[adsChecksumOff:], adsHeaderChecksum(image))
+
+	if count, _ := parseHeaderTo(io.Discard, image[:adsHeaderSize], "Local ADS", "[TEST]"); count != 0x0102 {
+		t.Errorf("parseHeaderTo count = %d, want %d", count, 0x0102)
+	}
+	var found bool
+	for _, issue := range ValidateADS(image) {
+		if issue.Code == ADSIssueTrackCount && issue.Track == 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("validator accepted a track count above the table size")
+	}
+}
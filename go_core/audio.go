@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ==========================================
+// 音訊匯入 (ADS 產生前的檢查與轉換)
+// 取代「直接跳過 44 bytes」：解析 RIFF 區塊，檢查是否為 16-bit 單聲道 PCM 與目標取樣率。
+// 安全帽實際的播放取樣率尚未確認 (16kHz 為假設值)，因此不符時預設只警告並原樣沿用；
+// 可選擇轉換或嚴格拒絕，並可做音量正規化與頭尾靜音裁切
+// ==========================================
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+
+	defaultSilenceDBFS = -50.0
+	normalizePeakLimit = 0.98 // 正規化後的峰值上限 (約 -0.2 dBFS)
+	minDBFS            = -120.0
+)
+
+// WAVFormat fmt 區塊內容
+type WAVFormat struct {
+	AudioFormat   uint16 `json:"audio_format"`
+	Channels      int    `json:"channels"`
+	SampleRate    int    `json:"sample_rate"`
+	BitsPerSample int    `json:"bits_per_sample"`
+}
+
+func (f WAVFormat) String() string {
+	return fmt.Sprintf("%d Hz %d-bit %dch (format %d)", f.SampleRate, f.BitsPerSample, f.Channels, f.AudioFormat)
+}
+
+// WAVFile 解析後的 WAV；Chunks 為 fmt/data 以外被略過的區塊 (LIST, fact ...)
+type WAVFile struct {
+	Format    WAVFormat
+	Data      []byte
+	Chunks    []string
+	Truncated bool // data 區塊宣告的長度超過檔案大小
+}
+
+// ParseWAV 依 RIFF 區塊解析 WAV
+func ParseWAV(raw []byte) (WAVFile, error) {
+	var w WAVFile
+	if len(raw) < 12 || string(raw[0:4]) != "RIFF" || string(raw[8:12]) != "WAVE" {
+		return w, fmt.Errorf("not a RIFF/WAVE file")
+	}
+	haveFmt, haveData := false, false
+	pos := 12
+	for pos+8 <= len(raw) {
+		id := string(raw[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(raw[pos+4:]))
+		start := pos + 8
+		end := start + size
+		if size < 0 || end > len(raw) {
+			end = len(raw)
+			if id == "data" {
+				w.Truncated = true
+			}
+		}
+		body := raw[start:end]
+
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return w, fmt.Errorf("fmt chunk too short (%d bytes)", len(body))
+			}
+			w.Format = WAVFormat{
+				AudioFormat:   binary.LittleEndian.Uint16(body[0:]),
+				Channels:      int(binary.LittleEndian.Uint16(body[2:])),
+				SampleRate:    int(binary.LittleEndian.Uint32(body[4:])),
+				BitsPerSample: int(binary.LittleEndian.Uint16(body[14:])),
+			}
+			// WAVE_FORMAT_EXTENSIBLE：實際格式在 SubFormat GUID 的前兩個 byte
+			if w.Format.AudioFormat == wavFormatExtensible && len(body) >= 26 {
+				w.Format.AudioFormat = binary.LittleEndian.Uint16(body[24:])
+			}
+			haveFmt = true
+		case "data":
+			w.Data = body
+			haveData = true
+		default:
+			w.Chunks = append(w.Chunks, id)
+		}
+
+		// 區塊長度為奇數時後面有 1 byte padding
+		pos = start + size + size&1
+		if pos < start {
+			break
+		}
+	}
+	if !haveFmt {
+		return w, fmt.Errorf("missing fmt chunk")
+	}
+	if !haveData {
+		return w, fmt.Errorf("missing data chunk")
+	}
+	if w.Format.Channels < 1 || w.Format.SampleRate < 1 {
+		return w, fmt.Errorf("invalid format: %s", w.Format)
+	}
+	return w, nil
+}
+
+// AudioOptions 匯入設定；零值代表不做任何處理，不符規格的檔案只加上警告
+type AudioOptions struct {
+	SampleRate    int     // 目標取樣率；0 = 不檢查也不轉換取樣率 (韌體取樣率尚未確認，見 DefaultSampleRate)
+	Convert       bool    // 非 16-bit / 多聲道 / 取樣率不符時轉換
+	Strict        bool    // 不轉換時拒絕不符規格的檔案 (預設只警告)
+	NormalizeDBFS float64 // RMS 目標 (例如 -16)；0 = 不調整
+	TrimSilence   bool    // 裁掉頭尾低於 SilenceDBFS 的樣本
+	SilenceDBFS   float64 // 靜音門檻 (0 = -50 dBFS)
+}
+
+// TrackReport 每個音軌的匯入報告 (與 ADS 一起輸出)
+type TrackReport struct {
+	ID             uint32    `json:"id"`
+	File           string    `json:"file"`
+	Source         WAVFormat `json:"source"`
+	Converted      bool      `json:"converted"`
+	Samples        int       `json:"samples"`
+	DurationMS     int       `json:"duration_ms"`
+	PeakDBFS       float64   `json:"peak_dbfs"`
+	RMSDBFS        float64   `json:"rms_dbfs"`
+	GainDB         float64   `json:"gain_db,omitempty"`
+	TrimmedMS      int       `json:"trimmed_ms,omitempty"`
+	ClippedSamples int       `json:"clipped_samples"`
+	Warnings       []string  `json:"warnings,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// targetRate 未指定目標取樣率時沿用來源的取樣率
+func (o AudioOptions) targetRate(source int) int {
+	if o.SampleRate > 0 {
+		return o.SampleRate
+	}
+	return source
+}
+
+// IngestWAV 將 WAV 轉成 ADS 使用的 16-bit 單聲道 PCM；
+// 已符合規格且不需處理時原樣沿用 data 區塊，結果與 Dart 編碼器相同
+func IngestWAV(raw []byte, opts AudioOptions) ([]byte, TrackReport, error) {
+	var report TrackReport
+	w, err := ParseWAV(raw)
+	if err != nil {
+		return nil, report, err
+	}
+	report.Source = w.Format
+	rate := opts.targetRate(w.Format.SampleRate)
+	if len(w.Chunks) > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("ignored chunks %v", w.Chunks))
+	}
+	if w.Truncated {
+		report.Warnings = append(report.Warnings, "data chunk is truncated")
+	}
+
+	samples, clipped, err := decodeSamples(w.Format, w.Data)
+	if err != nil {
+		return nil, report, err
+	}
+	report.ClippedSamples = clipped
+	if clipped > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d clipped samples", clipped))
+	}
+
+	conforming := w.Format.AudioFormat == wavFormatPCM && w.Format.BitsPerSample == 16 &&
+		w.Format.Channels == 1 && w.Format.SampleRate == rate
+	convert := !conforming && opts.Convert
+	if !conforming && !opts.Convert {
+		if opts.Strict {
+			return nil, report, fmt.Errorf("%s, want %d Hz 16-bit mono PCM (enable conversion)", w.Format, rate)
+		}
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%s, expected %d Hz 16-bit mono PCM; data kept as-is", w.Format, rate))
+	}
+
+	// 未轉換時正規化 / 裁切只改為 16-bit 單聲道，不改變取樣率
+	processed := convert || opts.NormalizeDBFS != 0 || opts.TrimSilence
+	if convert {
+		report.Converted = true
+		samples = resample(samples, w.Format.SampleRate, rate)
+	}
+	if opts.TrimSilence {
+		threshold := opts.SilenceDBFS
+		if threshold == 0 {
+			threshold = defaultSilenceDBFS
+		}
+		before := len(samples)
+		samples = trimSilence(samples, math.Pow(10, threshold/20))
+		report.TrimmedMS = (before - len(samples)) * 1000 / rate
+	}
+	if opts.NormalizeDBFS != 0 {
+		var gain float64
+		samples, gain = normalize(samples, math.Pow(10, opts.NormalizeDBFS/20))
+		report.GainDB = toDBFS(gain)
+	}
+
+	var pcm []byte
+	if processed {
+		pcm = encodePCM16(samples)
+	} else {
+		pcm = w.Data[:len(w.Data)&^1]
+	}
+
+	peak, rms := levels(samples)
+	report.Samples = len(pcm) / 2
+	if !processed && !conforming {
+		// 原樣沿用的資料不一定是 16-bit 單聲道：依來源格式計算長度
+		report.Samples = len(samples)
+		rate = w.Format.SampleRate
+	}
+	report.DurationMS = report.Samples * 1000 / rate
+	report.PeakDBFS = toDBFS(peak)
+	report.RMSDBFS = toDBFS(rms)
+	if report.Samples == 0 {
+		report.Warnings = append(report.Warnings, "no audio samples")
+	}
+	return pcm, report, nil
+}
+
+// decodeSamples 轉成 -1~1 的單聲道樣本 (多聲道取平均)，同時計算滿刻度樣本數
+func decodeSamples(f WAVFormat, data []byte) ([]float64, int, error) {
+	bytesPer := f.BitsPerSample / 8
+	switch {
+	case f.AudioFormat == wavFormatPCM && (f.BitsPerSample == 8 || f.BitsPerSample == 16 || f.BitsPerSample == 24 || f.BitsPerSample == 32):
+	case f.AudioFormat == wavFormatFloat && (f.BitsPerSample == 32 || f.BitsPerSample == 64):
+	default:
+		return nil, 0, fmt.Errorf("unsupported encoding: %s", f)
+	}
+
+	frameSize := bytesPer * f.Channels
+	n := len(data) / frameSize
+	out := make([]float64, n)
+	clipped := 0
+	// 整數 PCM 的正向滿刻度為 (2^(n-1)-1)/2^(n-1)，例如 8-bit 為 127/128
+	posMax := 1.0
+	if f.AudioFormat == wavFormatPCM {
+		full := float64(int64(1) << (f.BitsPerSample - 1))
+		posMax = (full - 1) / full
+	}
+	for i := 0; i < n; i++ {
+		sum := 0.0
+		for c := 0; c < f.Channels; c++ {
+			b := data[i*frameSize+c*bytesPer:]
+			var s float64
+			switch {
+			case f.AudioFormat == wavFormatFloat && bytesPer == 4:
+				s = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			case f.AudioFormat == wavFormatFloat:
+				s = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			case bytesPer == 1:
+				s = (float64(b[0]) - 128) / 128
+			case bytesPer == 2:
+				s = float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+			case bytesPer == 3:
+				v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+				s = float64(v) / 8388608
+			default:
+				s = float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648
+			}
+			if s >= posMax || s <= -1 {
+				clipped++
+			}
+			sum += s
+		}
+		out[i] = sum / float64(f.Channels)
+	}
+	return out, clipped, nil
+}
+
+// resample 線性內插轉換取樣率；降頻前先以 FIR 低通濾掉新 Nyquist 頻率以上的成分，避免混疊
+func resample(in []float64, from, to int) []float64 {
+	if from == to || len(in) == 0 {
+		return in
+	}
+	if to < from {
+		// 截止頻率留 10% 過渡帶 (以來源取樣率正規化，0.5 = Nyquist)
+		in = lowPass(in, 0.45*float64(to)/float64(from))
+	}
+	n := int(int64(len(in)) * int64(to) / int64(from))
+	out := make([]float64, n)
+	step := float64(from) / float64(to)
+	for i := range out {
+		pos := float64(i) * step
+		idx := int(pos)
+		frac := pos - float64(idx)
+		if idx+1 < len(in) {
+			out[i] = in[idx]*(1-frac) + in[idx+1]*frac
+		} else {
+			out[i] = in[len(in)-1]
+		}
+	}
+	return out
+}
+
+// lowPassTaps FIR 的單邊長度 (總長 2·lowPassTaps+1)
+const lowPassTaps = 32
+
+// lowPass Blackman 窗的 windowed-sinc 低通濾波；cutoff 以取樣率正規化 (0~0.5)，
+// 係數總和為 1 (直流增益不變)，頭尾以 0 延伸
+func lowPass(in []float64, cutoff float64) []float64 {
+	const m = 2 * lowPassTaps
+	kernel := make([]float64, m+1)
+	sum := 0.0
+	for i := range kernel {
+		x := float64(i - lowPassTaps)
+		h := 2 * cutoff
+		if x != 0 {
+			h = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(i)/m) + 0.08*math.Cos(4*math.Pi*float64(i)/m)
+		kernel[i] = h * w
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	out := make([]float64, len(in))
+	for i := range out {
+		acc := 0.0
+		for k, c := range kernel {
+			if j := i + k - lowPassTaps; j >= 0 && j < len(in) {
+				acc += in[j] * c
+			}
+		}
+		out[i] = acc
+	}
+	return out
+}
+
+func trimSilence(in []float64, threshold float64) []float64 {
+	start, end := 0, len(in)
+	for start < end && math.Abs(in[start]) < threshold {
+		start++
+	}
+	for end > start && math.Abs(in[end-1]) < threshold {
+		end--
+	}
+	return in[start:end]
+}
+
+// normalize 調整到目標 RMS，峰值不超過 normalizePeakLimit；回傳實際增益 (倍數)
+func normalize(in []float64, targetRMS float64) ([]float64, float64) {
+	peak, rms := levels(in)
+	if rms == 0 {
+		return in, 1
+	}
+	gain := targetRMS / rms
+	if peak*gain > normalizePeakLimit {
+		gain = normalizePeakLimit / peak
+	}
+	out := make([]float64, len(in))
+	for i, s := range in {
+		out[i] = s * gain
+	}
+	return out, gain
+}
+
+func levels(in []float64) (peak, rms float64) {
+	if len(in) == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	for _, s := range in {
+		if a := math.Abs(s); a > peak {
+			peak = a
+		}
+		sum += s * s
+	}
+	return peak, math.Sqrt(sum / float64(len(in)))
+}
+
+func toDBFS(v float64) float64 {
+	if v <= 0 {
+		return minDBFS
+	}
+	return math.Max(minDBFS, math.Round(20*math.Log10(v)*10)/10)
+}
+
+func encodePCM16(samples []float64) []byte {
+	out := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		v := math.Round(s * 32768)
+		v = math.Max(-32768, math.Min(32767, v))
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(v)))
+	}
+	return out
+}
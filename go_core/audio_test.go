@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func testWAV(rate, bits, channels int, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(data)))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{
+		uint32(16), uint16(wavFormatPCM), uint16(channels), uint32(rate),
+		uint32(rate * channels * bits / 8), uint16(channels * bits / 8), uint16(bits),
+	} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+// 未指定目標取樣率時 (韌體取樣率未確認) 不檢查也不轉換取樣率
+func TestIngestWAVKeepsRateWhenUnset(t *testing.T) {
+	data := []byte{0, 1, 0, 2, 0, 3, 0, 4}
+	for _, opts := range []AudioOptions{{}, {Convert: true, Strict: true}} {
+		pcm, report, err := IngestWAV(testWAV(22050, 16, 1, data), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Converted || len(report.Warnings) != 0 || !bytes.Equal(pcm, data) {
+			t.Errorf("opts %+v: converted=%v warnings=%v pcm=% x", opts, report.Converted, report.Warnings, pcm)
+		}
+	}
+}
+
+func TestIngestWAVNonConforming(t *testing.T) {
+	data := []byte{0, 1, 0, 2, 0, 3, 0, 4}
+	raw := testWAV(22050, 16, 1, data)
+
+	tests := []struct {
+		name      string
+		opts      AudioOptions
+		wantErr   bool
+		converted bool
+		asIs      bool
+	}{
+		{"default keeps data and warns", AudioOptions{SampleRate: 16000}, false, false, true},
+		{"strict rejects", AudioOptions{SampleRate: 16000, Strict: true}, true, false, false},
+		{"convert resamples", AudioOptions{SampleRate: 16000, Convert: true, Strict: true}, false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pcm, report, err := IngestWAV(raw, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if report.Converted != tt.converted {
+				t.Errorf("Converted = %v, want %v", report.Converted, tt.converted)
+			}
+			if tt.asIs {
+				if !bytes.Equal(pcm, data) {
+					t.Errorf("pcm = % x, want data kept as-is", pcm)
+				}
+				if len(report.Warnings) == 0 {
+					t.Error("no warning for non-conforming WAV")
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeSamplesClipping(t *testing.T) {
+	tests := []struct {
+		name string
+		f    WAVFormat
+		data []byte
+		want int
+	}{
+		{"8-bit", WAVFormat{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 8000, BitsPerSample: 8}, []byte{255, 0, 128, 254}, 2},
+		{"16-bit", WAVFormat{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 16000, BitsPerSample: 16}, []byte{0xFF, 0x7F, 0x00, 0x80, 0xFE, 0x7F}, 2},
+		{"24-bit", WAVFormat{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 16000, BitsPerSample: 24}, []byte{0xFF, 0xFF, 0x7F, 0x00, 0x00, 0x01}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, clipped, err := decodeSamples(tt.f, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if clipped != tt.want {
+				t.Errorf("clipped = %d, want %d", clipped, tt.want)
+			}
+		})
+	}
+}
+
+func sine(freq, rate float64, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = 0.5 * math.Sin(2*math.Pi*freq*float64(i)/rate)
+	}
+	return out
+}
+
+// 降頻前的低通濾波：通帶幾乎不變，新 Nyquist 以上的成分不能混疊進來
+func TestResampleAntiAliasing(t *testing.T) {
+	rms := func(x []float64) float64 {
+		// 略過頭尾濾波器的暫態
+		_, r := levels(x[lowPassTaps : len(x)-lowPassTaps])
+		return r
+	}
+	const from, to = 44100, 16000
+	in := rms(sine(1000, from, from))
+
+	pass := rms(resample(sine(1000, from, from), from, to))
+	if db := toDBFS(pass / in); math.Abs(db) > 0.5 {
+		t.Errorf("1 kHz passband changed by %.2f dB", db)
+	}
+	// 10 kHz 高於 8 kHz Nyquist；只做線性內插時會混疊成 6 kHz 且幾乎不衰減
+	alias := rms(resample(sine(10000, from, from), from, to))
+	if db := toDBFS(alias / in); db > -40 {
+		t.Errorf("10 kHz aliased at %.1f dB, want below -40 dB", db)
+	}
+
+	// 升頻與相同取樣率不濾波
+	up := sine(1000, 8000, 800)
+	if out := resample(up, 8000, 8000); &out[0] != &up[0] {
+		t.Error("same rate should return the input")
+	}
+}
+
+func TestLowPassUnityDCGain(t *testing.T) {
+	in := make([]float64, 200)
+	for i := range in {
+		in[i] = 0.25
+	}
+	out := lowPass(in, 0.2)
+	for i := lowPassTaps; i < len(out)-lowPassTaps; i++ {
+		if math.Abs(out[i]-0.25) > 1e-9 {
+			t.Fatalf("out[%d] = %v, want 0.25", i, out[i])
+		}
+	}
+}
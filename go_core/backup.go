@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"BM2/protocol"
+)
+
+// ==========================================
+// 設備備份：以 0xC6 分頁讀回整個音訊區，還原 +0x80 後存成 .ads
+// 用於重燒前留存出貨內容，或檢查退回的設備
+// ==========================================
+
+const (
+	backupPageSize     = 192
+	backupPageRetries  = 5
+	backupPageTimeout  = 2500 * time.Millisecond
+	backupMaxImageSize = 16 << 20 // Header 內容異常時避免讀取無止盡
+)
+
+// readPage 讀取單一分頁；只接受這次讀取指令 Frame ID 的回傳，逾時或資料為空時重試
+func readPage(ctx context.Context, t Transporter, offset, size int) ([]byte, error) {
+	for attempt := 0; attempt < backupPageRetries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fid, err := sendReadCommand(t, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(backupPageTimeout)
+		for {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				break
+			}
+			f, err := t.ReadFrame(ctx, remaining)
+			if err != nil {
+				break
+			}
+			if f.Kind != FrameData || f.FID != fid {
+				// 前一次逾時的遲到回傳不可當成這一頁
+				continue
+			}
+			reply, err := protocol.DecodeReadReply(f.Payload)
+			if err != nil || len(reply.Data) == 0 {
+				continue
+			}
+			if len(reply.Data) > size {
+				reply.Data = reply.Data[:size]
+			}
+			return reply.Data, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("read timeout at offset %d", offset)
+}
+
+// readRange 讀取 [offset, offset+size)，每讀完一頁回報一次
+func readRange(ctx context.Context, t Transporter, offset, size int, progress func(done int)) ([]byte, error) {
+	buf := make([]byte, 0, size)
+	for len(buf) < size {
+		n := size - len(buf)
+		if n > backupPageSize {
+			n = backupPageSize
+		}
+		page, err := readPage(ctx, t, offset+len(buf), n)
+		if err != nil {
+			return buf, err
+		}
+		buf = append(buf, page...)
+		if progress != nil {
+			progress(len(buf))
+		}
+	}
+	return buf, nil
+}
+
+// BackupDevice 讀回已連線設備的完整 ADS 映像 (未編碼格式，可直接存檔或燒錄)
+// 燒錄中斷的設備 Checksum 仍為 0xFFFF，此時以讀回的 Header 重新計算；
+// requireChecksum (複製模式的來源) 時改為拒絕 0xFFFF 或與 Header 不符的設備
+func BackupDevice(ctx context.Context, t Transporter, mac, prefix string, requireChecksum bool) ([]byte, error) {
+	if !unlockDevice(ctx, t, prefix) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("unlock failed")
+	}
+
+	reportLog("%s 📥 讀取 Header...", prefix)
+	header, err := readRange(ctx, t, 0, adsHeaderSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	if header[0] != 0x27 || header[1] != 0x9D {
+		return nil, fmt.Errorf("no ADS header on device (magic %02X %02X)", header[0], header[1])
+	}
+	count := adsTrackCount(header)
+	if count > adsMaxTracks {
+		return nil, fmt.Errorf("device header has %d tracks (max %d)", count, adsMaxTracks)
+	}
+
+	total := adsHeaderSize
+	for i := 0; i < count; i++ {
+		entry := 4 + i*12
+		offset := int(binary.LittleEndian.Uint32(header[entry+4:]))
+		size := int(binary.LittleEndian.Uint32(header[entry+8:]))
+		if size > 0 && offset+size > total {
+			total = offset + size
+		}
+	}
+	if total > backupMaxImageSize {
+		return nil, fmt.Errorf("device header describes %d bytes (max %d)", total, backupMaxImageSize)
+	}
+
+	reportLog("%s 📥 讀取音訊區 (Total: %d bytes)...", prefix, total)
+	lastPct := -1
+	body, err := readRange(ctx, t, adsHeaderSize, total-adsHeaderSize, func(done int) {
+		reportFlashProgress(mac, "", prefix, adsHeaderSize+done, total, &lastPct)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	image := decodeAudioData(append(header, body...))
+	stored, computed := binary.LittleEndian.Uint16(image[adsChecksumOff:]), adsHeaderChecksum(image)
+	switch {
+	case requireChecksum && stored == 0xFFFF:
+		return nil, fmt.Errorf("device checksum is 0xFFFF (flash incomplete)")
+	case requireChecksum && stored != computed:
+		return nil, fmt.Errorf("device checksum 0x%04X does not match header (0x%04X)", stored, computed)
+	case stored == 0xFFFF:
+		reportLog("%s ⚠️ 設備 Checksum 為 0xFFFF (燒錄未完成)，以讀回內容重新計算", prefix)
+		binary.LittleEndian.PutUint16(image[adsChecksumOff:], computed)
+	}
+	for _, issue := range ValidateADS(image) {
+		reportLog("%s ⚠️ 備份內容: %s", prefix, issue)
+	}
+	reportLog("%s ✅ 備份完成 (%d bytes, %d tracks)", prefix, len(image), count)
+	return image, nil
+}
+
+// backupFromPort 連線 → 讀回 → 斷線
+func backupFromPort(ctx context.Context, port, mac, prefix string, ble BLEConfig, requireChecksum bool) ([]byte, error) {
+	t := newTransport(port, ble)
+	if err := t.Connect(ctx, mac); err != nil {
+		return nil, err
+	}
+	defer t.Disconnect()
+	return BackupDevice(ctx, t, mac, prefix, requireChecksum)
+}
+
+// IPC BACKUP 進行中的取消函式 (key: MAC)，由 STOP / CANCEL 中斷
+var (
+	backupMu      sync.Mutex
+	backupCancels = make(map[string]context.CancelFunc)
+)
+
+// startBackup 登記一個可取消的備份；同一台設備已在備份中時回傳 false
+func startBackup(mac string) (context.Context, bool) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	if _, busy := backupCancels[mac]; busy {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	backupCancels[mac] = cancel
+	return ctx, true
+}
+
+func finishBackup(mac string) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	if cancel, ok := backupCancels[mac]; ok {
+		cancel()
+		delete(backupCancels, mac)
+	}
+}
+
+// cancelBackups 取消指定設備的備份；mac 為空時取消全部
+func cancelBackups(mac string) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	for m, cancel := range backupCancels {
+		if mac == "" || m == mac {
+			cancel()
+		}
+	}
+}
+
+// BackupToFile 讀回設備並存檔，供 CLI 與 IPC 的 BACKUP 使用
+func BackupToFile(ctx context.Context, port, mac, path string, ble BLEConfig) (int, error) {
+	image, err := backupFromPort(ctx, port, mac, fmt.Sprintf("[%s][BACKUP]", port), ble, false)
+	if err != nil {
+		return 0, err
+	}
+	return len(image), os.WriteFile(path, image, 0o644)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"BM2/protocol"
+)
+
+// staleReadTransport 在每次讀取指令後先送出一個舊 Frame ID 的 0xC7 (前一次逾時的遲到回傳)
+type staleReadTransport struct {
+	Transporter
+	stale []Frame
+}
+
+func (s *staleReadTransport) SendCmd(target byte, payload []byte) (uint16, error) {
+	fid, err := s.Transporter.SendCmd(target, payload)
+	if err == nil && len(payload) > 0 && payload[0] == protocol.OpRead {
+		garbage := protocol.ReadReply{Data: bytes.Repeat([]byte{0xEE}, 16)}.Encode()
+		s.stale = append(s.stale, Frame{Kind: FrameData, Target: target, FID: fid - 1, Payload: garbage})
+	}
+	return fid, err
+}
+
+func (s *staleReadTransport) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	if len(s.stale) > 0 {
+		f := s.stale[0]
+		s.stale = s.stale[1:]
+		return f, nil
+	}
+	return s.Transporter.ReadFrame(ctx, timeout)
+}
+
+// flashedHelmet 模擬已燒錄 meta 的設備；checksum 為最後回寫到 604~605 的值 (不經 +0x80 編碼)
+func flashedHelmet(meta FileMeta, checksum []byte) *EmulatedHelmet {
+	helmet := NewEmulatedHelmet(testMAC, len(meta.EncodedData), EmulatorFaults{})
+	copy(helmet.Flash, meta.EncodedData)
+	copy(helmet.Flash[adsChecksumOff:], checksum)
+	return helmet
+}
+
+func TestBackupIgnoresStaleReadReplies(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 1000, 2, 600))
+	helmet := flashedHelmet(meta, meta.RawData[adsChecksumOff:adsChecksumOff+2])
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tr := NewEmulatedTransport(helmet)
+	if err := tr.Connect(ctx, testMAC); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Disconnect()
+
+	image, err := BackupDevice(ctx, &staleReadTransport{Transporter: tr}, testMAC, "[TEST]", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image, meta.RawData) {
+		t.Fatal("backup differs from the flashed image")
+	}
+}
+
+// 燒錄中斷的設備：一般備份重新計算 Checksum，複製來源則必須拒絕
+func TestBackupIncompleteSource(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 1000))
+	tests := []struct {
+		name     string
+		checksum []byte
+	}{
+		{"unwritten checksum", []byte{0xFF, 0xFF}},
+		{"mismatched checksum", []byte{0x12, 0x34}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helmet := flashedHelmet(meta, tt.checksum)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			tr := NewEmulatedTransport(helmet)
+			if err := tr.Connect(ctx, testMAC); err != nil {
+				t.Fatal(err)
+			}
+			defer tr.Disconnect()
+
+			if _, err := BackupDevice(ctx, tr, testMAC, "[TEST]", true); err == nil {
+				t.Error("clone source with bad checksum accepted")
+			}
+			image, err := BackupDevice(ctx, tr, testMAC, "[TEST]", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.checksum[0] == 0xFF && !bytes.Equal(image, meta.RawData) {
+				t.Error("plain backup did not recompute the unwritten checksum")
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"BM2/protocol"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// ==========================================
+// 直接以主機藍牙 GATT 連線安全帽 (不經 Dongle)
+// ==========================================
+
+// BLEPortPrefix Order.Ports 中以此開頭的項目視為主機藍牙虛擬 Port (例如 "BLE:1")
+const BLEPortPrefix = "BLE:"
+
+// BLEConfig GATT 服務與特徵值 UUID
+type BLEConfig struct {
+	Service string
+	Write   string
+	Notify  string
+}
+
+// defaultBLEConfig 為 Nordic UART Service (NUS) 的標準 UUID
+// (Nordic Semiconductor 公開的 NUS 規格：服務 6E400001，RX/寫入 6E400002，TX/通知 6E400003)。
+// 安全帽韌體是否使用 NUS 尚未以實機確認；不同時由 Order 的 ble_* 欄位覆寫
+var defaultBLEConfig = BLEConfig{
+	Service: "6e400001-b5a3-f393-e0a9-e50e24dcca9e",
+	Write:   "6e400002-b5a3-f393-e0a9-e50e24dcca9e",
+	Notify:  "6e400003-b5a3-f393-e0a9-e50e24dcca9e",
+}
+
+// bleConfig 以 Order 的 ble_* 欄位覆寫預設值 (只影響這次工作)
+func bleConfig(order Order) BLEConfig {
+	c := defaultBLEConfig
+	if order.BLEService != "" {
+		c.Service = order.BLEService
+	}
+	if order.BLEWrite != "" {
+		c.Write = order.BLEWrite
+	}
+	if order.BLENotify != "" {
+		c.Notify = order.BLENotify
+	}
+	return c
+}
+
+// bleAddresses 由 RunGlobalScanner 記錄符合目標的設備位址 (mac -> bluetooth.Address)，停工時清空
+var bleAddresses sync.Map
+
+func rememberBLEAddress(mac string, addr bluetooth.Address) {
+	bleAddresses.Store(strings.ToUpper(mac), addr)
+}
+
+func forgetBLEAddresses() {
+	bleAddresses.Range(func(key, _ interface{}) bool {
+		bleAddresses.Delete(key)
+		return true
+	})
+}
+
+func isBLEPort(port string) bool {
+	return strings.HasPrefix(strings.ToUpper(port), BLEPortPrefix)
+}
+
+// BLETransport 以 GATT Write / Notify 傳送與 SerialAdaptor 相同的 0x25 封包
+type BLETransport struct {
+	PortName string
+	Config   BLEConfig
+
+	device    bluetooth.Device
+	write     bluetooth.DeviceCharacteristic
+	notify    bluetooth.DeviceCharacteristic
+	connected bool
+	mtu       int
+
+	internalFid uint16
+	decoder     FrameDecoder
+	rx          chan []byte
+	tap         func(dir string, data []byte)
+}
+
+// SetTap 設定原始收發的監聽函式 (Trace 錄製用)
+func (b *BLETransport) SetTap(tap func(dir string, data []byte)) {
+	b.tap = tap
+}
+
+func NewBLETransport(portName string, cfg BLEConfig) *BLETransport {
+	return &BLETransport{PortName: portName, Config: cfg}
+}
+
+func (b *BLETransport) Connect(ctx context.Context, mac string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	value, ok := bleAddresses.Load(strings.ToUpper(mac))
+	if !ok {
+		return fmt.Errorf("ble: %s 尚未被掃描到", mac)
+	}
+	device, err := connectBLE(ctx, value.(bluetooth.Address))
+	if err != nil {
+		return err
+	}
+
+	serviceUUID, err := bluetooth.ParseUUID(b.Config.Service)
+	if err != nil {
+		device.Disconnect()
+		return err
+	}
+	writeUUID, err := bluetooth.ParseUUID(b.Config.Write)
+	if err != nil {
+		device.Disconnect()
+		return err
+	}
+	notifyUUID, err := bluetooth.ParseUUID(b.Config.Notify)
+	if err != nil {
+		device.Disconnect()
+		return err
+	}
+
+	services, err := device.DiscoverServices([]bluetooth.UUID{serviceUUID})
+	if err != nil || len(services) == 0 {
+		device.Disconnect()
+		return fmt.Errorf("ble: 找不到服務 %s (%v)", b.Config.Service, err)
+	}
+	chars, err := services[0].DiscoverCharacteristics([]bluetooth.UUID{writeUUID, notifyUUID})
+	if err != nil || len(chars) < 2 {
+		device.Disconnect()
+		return fmt.Errorf("ble: 找不到特徵值 (%v)", err)
+	}
+
+	b.device = device
+	for _, c := range chars {
+		switch c.UUID() {
+		case writeUUID:
+			b.write = c
+		case notifyUUID:
+			b.notify = c
+		}
+	}
+
+	b.rx = make(chan []byte, 256)
+	rx := b.rx
+	err = b.notify.EnableNotifications(func(buf []byte) {
+		data := make([]byte, len(buf))
+		copy(data, buf)
+		select {
+		case rx <- data:
+		default:
+			// 讀取端太慢時丟棄，由上層重試
+		}
+	})
+	if err != nil {
+		device.Disconnect()
+		return fmt.Errorf("ble notify: %w", err)
+	}
+
+	b.mtu = 20
+	if mtu, err := b.write.GetMTU(); err == nil && mtu > 23 {
+		b.mtu = int(mtu) - 3
+	}
+	b.connected = true
+	b.internalFid = 0
+	b.decoder.Reset()
+	return nil
+}
+
+// connectBLE adapter.Connect 不接受 Context：取消時立即返回，晚到的連線建立後再斷開
+func connectBLE(ctx context.Context, addr bluetooth.Address) (bluetooth.Device, error) {
+	type result struct {
+		device bluetooth.Device
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		device, err := adapter.Connect(addr, bluetooth.ConnectionParams{
+			ConnectionTimeout: bluetooth.NewDuration(10 * time.Second),
+		})
+		done <- result{device, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return r.device, fmt.Errorf("ble connect: %w", r.err)
+		}
+		if err := ctx.Err(); err != nil {
+			r.device.Disconnect()
+			return r.device, err
+		}
+		return r.device, nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.device.Disconnect()
+			}
+		}()
+		return bluetooth.Device{}, ctx.Err()
+	}
+}
+
+// adapterScan / adapterStopScan 包裝全域 adapter，測試時替換
+var (
+	adapterScan     = func(cb func(*bluetooth.Adapter, bluetooth.ScanResult)) error { return adapter.Scan(cb) }
+	adapterStopScan = func() error { return adapter.StopScan() }
+)
+
+// scanBLE adapter.Scan 不接受 Context：取消後持續呼叫 StopScan 直到 Scan 返回。
+// 只送一次不夠，StopScan 若早於 Scan 開始就不會生效，Scan 會在沒有廣播時永遠阻塞
+func scanBLE(ctx context.Context, cb func(*bluetooth.Adapter, bluetooth.ScanResult)) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			adapterStopScan()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return adapterScan(cb)
+}
+
+func (b *BLETransport) Disconnect() error {
+	if b.connected {
+		b.connected = false
+		b.device.Disconnect()
+	}
+	return nil
+}
+
+func (b *BLETransport) SendCmd(target byte, payload []byte) (uint16, error) {
+	if !b.connected {
+		return 0, fmt.Errorf("port closed")
+	}
+	b.internalFid++
+	f := b.internalFid
+	packet := buildFrame(target, f, 0, payload)
+	if b.tap != nil {
+		b.tap(TraceTX, packet)
+	}
+
+	// 依 MTU 分段寫入
+	for len(packet) > 0 {
+		n := b.mtu
+		if n > len(packet) {
+			n = len(packet)
+		}
+		if _, err := b.write.WriteWithoutResponse(packet[:n]); err != nil {
+			return f, err
+		}
+		packet = packet[n:]
+	}
+	return f, nil
+}
+
+func (b *BLETransport) SendAudioChunk(offset int, data []byte) (uint16, error) {
+	return SendRequest(b, protocol.WriteAudio{Offset: uint32(offset), Data: data})
+}
+
+func (b *BLETransport) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	if !b.connected {
+		return Frame{}, fmt.Errorf("port closed")
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if f, ok := b.decoder.Next(); ok {
+			return f, nil
+		}
+		select {
+		case data := <-b.rx:
+			if b.tap != nil {
+				b.tap(TraceRX, data)
+			}
+			b.decoder.Feed(data)
+		case <-timer.C:
+			return Frame{}, ErrACKTimeout
+		case <-ctx.Done():
+			return Frame{}, ctx.Err()
+		}
+	}
+}
+
+func (b *BLETransport) ResetBuffer() {
+	for {
+		select {
+		case <-b.rx:
+		default:
+			b.decoder.Reset()
+			return
+		}
+	}
+}
+
+func (b *BLETransport) WaitForACK(ctx context.Context, fid uint16, timeout time.Duration) error {
+	return waitForACK(ctx, b, fid, timeout)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// fakeScanner 模擬 adapter 的行為：Scan 開始前的 StopScan 不生效，Scan 在沒有廣播時一直阻塞
+type fakeScanner struct {
+	mu       sync.Mutex
+	scanning chan struct{}
+}
+
+func (f *fakeScanner) scan(cb func(*bluetooth.Adapter, bluetooth.ScanResult)) error {
+	time.Sleep(50 * time.Millisecond) // 進入 Scan 到掃描真正開始之間的空窗
+	stop := make(chan struct{})
+	f.mu.Lock()
+	f.scanning = stop
+	f.mu.Unlock()
+	<-stop
+	return nil
+}
+
+func (f *fakeScanner) stopScan() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.scanning != nil {
+		close(f.scanning)
+		f.scanning = nil
+	}
+	return nil
+}
+
+func useFakeScanner(t *testing.T) *fakeScanner {
+	f := &fakeScanner{}
+	scan, stop := adapterScan, adapterStopScan
+	adapterScan, adapterStopScan = f.scan, f.stopScan
+	t.Cleanup(func() { adapterScan, adapterStopScan = scan, stop })
+	return f
+}
+
+// 停工早於 Scan 開始 (StopScan 落空) 時，scanBLE 仍須返回，否則 Stop 的 routines.Wait 會卡住
+func TestScanStopsWhenCancelledBeforeStart(t *testing.T) {
+	useFakeScanner(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	adapterStopScan() // Stop 在 Scan 之前送出的 StopScan
+
+	done := make(chan error, 1)
+	go func() { done <- scanBLE(ctx, func(*bluetooth.Adapter, bluetooth.ScanResult) {}) }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scan did not stop after the context was cancelled")
+	}
+}
+
+func TestStopEndsRunningScanner(t *testing.T) {
+	useFakeScanner(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &FactoryManager{ctx: ctx, cancel: cancel}
+	m.spawn(m.RunGlobalScanner)
+
+	stopped := make(chan struct{})
+	go func() {
+		m.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not return while the scanner was idle")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ==========================================
+// 每台設備的重試額度
+// 比對不符 (REBURN)、釋放 (RELEASE) 次數與失敗作業的累計時間超過上限即進入 FAILED：
+// 不再掃描、回報 UI，需操作員 RESET_DEVICE 才會重新排入
+// 只在作業失敗後結算，進行中的作業不會因額度被中斷；各項上限預設關閉，由 Order 開啟
+// ==========================================
+
+// DeviceBudget 單一設備 (MAC + ADS 雜湊) 已使用的額度
+type DeviceBudget struct {
+	MAC      string        `json:"mac"`
+	Hash     string        `json:"hash"`
+	Reburns  int           `json:"reburns"`
+	Releases int           `json:"releases"`
+	Elapsed  time.Duration `json:"-"`                // 失敗作業的累計時間
+	Failed   string        `json:"failed,omitempty"` // FAILED 的原因
+
+	RetryAfter time.Time `json:"-"` // RELEASE 後的退避期限 (只在本次工作有效，不寫入日誌)
+}
+
+// deviceBudgetReport UI 用的格式 (時間以秒表示)
+type deviceBudgetReport struct {
+	DeviceBudget
+	ElapsedSec int `json:"elapsed_sec"`
+}
+
+// maxReburns / maxReleases 次數上限預設關閉：0 或負數代表不限制
+func (m *FactoryManager) maxReburns() int {
+	return m.Config.MaxReburns
+}
+
+func (m *FactoryManager) maxReleases() int {
+	return m.Config.MaxReleases
+}
+
+// maxDeviceTime 作業時間上限預設關閉：0 或負數代表不限制
+func (m *FactoryManager) maxDeviceTime() time.Duration {
+	if m.Config.MaxDeviceSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(m.Config.MaxDeviceSeconds) * time.Second
+}
+
+// budget 取得 (必要時建立) 設備的額度紀錄；呼叫端須持有 MapMutex
+func (m *FactoryManager) budget(mac string) *DeviceBudget {
+	key := m.progressKey(mac)
+	b := m.Budgets[key]
+	if b == nil {
+		b = &DeviceBudget{MAC: mac, Hash: key.Hash}
+		m.Budgets[key] = b
+	}
+	return b
+}
+
+// isFailed 呼叫端須持有 MapMutex
+func (m *FactoryManager) isFailed(mac string) bool {
+	b := m.Budgets[m.progressKey(mac)]
+	return b != nil && b.Failed != ""
+}
+
+// chargeBudget 記錄一次失敗的作業 (REBURN 或 RELEASE)，回傳額度用盡的原因 (未用盡為空字串)；
+// 呼叫端須持有 MapMutex，日誌紀錄加入 batch 於解鎖後寫入
+func (m *FactoryManager) chargeBudget(mac string, elapsed time.Duration, reburn, release bool, batch *journalBatch) string {
+	b := m.budget(mac)
+	hash := m.Meta.SHA256
+	b.Elapsed += elapsed
+	batch.add(journalTime, mac, hash).Elapsed = elapsed.Milliseconds()
+	if reburn {
+		b.Reburns++
+		batch.add(journalReburn, mac, hash)
+	}
+	if release {
+		b.Releases++
+		batch.add(journalFail, mac, hash)
+	}
+
+	if max := m.maxReburns(); max > 0 && b.Reburns > max {
+		return fmt.Sprintf("比對不符 %d 次，超過重燒上限 %d", b.Reburns, max)
+	}
+	if max := m.maxReleases(); max > 0 && b.Releases > max {
+		return fmt.Sprintf("釋放 %d 次，超過上限 %d", b.Releases, max)
+	}
+	if limit := m.maxDeviceTime(); limit > 0 && b.Elapsed >= limit {
+		return fmt.Sprintf("累計作業時間 %s 超過上限 %s", b.Elapsed.Round(time.Second), limit)
+	}
+	return ""
+}
+
+// failDevice 進入 FAILED 並通知 UI；呼叫端須持有 MapMutex
+func (m *FactoryManager) failDevice(port, mac, reason string, batch *journalBatch) {
+	b := m.budget(mac)
+	b.Failed = reason
+	batch.add(journalFailed, mac, m.Meta.SHA256).Reason = reason
+	sendLog(port, fmt.Sprintf("⛔ %s 已停止重試: %s", mac, reason))
+	sendEvent("DEVICE_FAILED", port, reason, budgetReport(b))
+}
+
+// ResetDevice 操作員重置設備的額度與 FAILED 狀態 (所有 ADS 雜湊)，下次掃描到時重新排入
+func (m *FactoryManager) ResetDevice(mac string) bool {
+	m.MapMutex.Lock()
+	found := false
+	for key := range m.Budgets {
+		if key.MAC == mac {
+			delete(m.Budgets, key)
+			found = true
+		}
+	}
+	m.MapMutex.Unlock()
+
+	reset, err := resetJournalBudgets(m.Journal, mac)
+	m.journalError(mac, err)
+	if !found && !reset {
+		return false
+	}
+	sendLog("SYSTEM", fmt.Sprintf("🔄 已重置設備: %s", mac))
+	return true
+}
+
+// FailedDevices 目前為 FAILED 的設備：本次工作以記憶體為準，其他 ADS 雜湊取自日誌
+func (m *FactoryManager) FailedDevices() []deviceBudgetReport {
+	m.MapMutex.Lock()
+	hash := m.Meta.SHA256
+	list := []deviceBudgetReport{}
+	for key, b := range m.Budgets {
+		if b.Failed != "" && key.Hash == hash {
+			list = append(list, budgetReport(b))
+		}
+	}
+	m.MapMutex.Unlock()
+	for _, r := range failedJournalEntries(m.Journal) {
+		if r.Hash != hash {
+			list = append(list, r)
+		}
+	}
+	sortBudgetReports(list)
+	return list
+}
+
+func budgetReport(b *DeviceBudget) deviceBudgetReport {
+	return deviceBudgetReport{DeviceBudget: *b, ElapsedSec: int(b.Elapsed / time.Second)}
+}
+
+func sortBudgetReports(list []deviceBudgetReport) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].MAC != list[j].MAC {
+			return list[i].MAC < list[j].MAC
+		}
+		return list[i].Hash < list[j].Hash
+	})
+}
+
+// resetJournalBudgets 重置日誌中該 MAC 所有 ADS 雜湊的重試紀錄；回傳是否有紀錄被重置
+func resetJournalBudgets(j *Journal, mac string) (bool, error) {
+	found := false
+	for _, e := range j.Entries("") {
+		if e.MAC != mac || !e.hasBudget() {
+			continue
+		}
+		if err := j.Reset(e.MAC, e.Hash); err != nil {
+			return found, err
+		}
+		found = true
+	}
+	return found, nil
+}
+
+// failedJournalEntries 日誌中所有 ADS 雜湊的 FAILED 設備
+func failedJournalEntries(j *Journal) []deviceBudgetReport {
+	list := []deviceBudgetReport{}
+	for _, e := range j.Entries("") {
+		if e.Failed != "" {
+			list = append(list, budgetReport(e.budget()))
+		}
+	}
+	sortBudgetReports(list)
+	return list
+}
+
+// 以下供未啟動工作 (沒有 FactoryManager) 時的 RESET_DEVICE / LIST_FAILED 直接操作日誌檔
+
+// ResetDeviceInJournal 開啟日誌、重置該 MAC 的重試紀錄後關閉
+func ResetDeviceInJournal(path, mac string) (bool, error) {
+	j, err := OpenJournal(path)
+	if err != nil {
+		return false, err
+	}
+	found, err := resetJournalBudgets(j, mac)
+	if cerr := j.Close(); err == nil {
+		err = cerr
+	}
+	return found, err
+}
+
+// FailedDevicesInJournal 讀取日誌中所有 FAILED 設備
+func FailedDevicesInJournal(path string) ([]deviceBudgetReport, error) {
+	j, err := OpenJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Close()
+	return failedJournalEntries(j), nil
+}
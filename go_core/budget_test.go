@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 作業時間上限預設關閉；開啟時只累計失敗作業的時間
+func TestDeviceTimeBudget(t *testing.T) {
+	m := &FactoryManager{Budgets: make(map[ProgressKey]*DeviceBudget)}
+	var batch journalBatch
+	if reason := m.chargeBudget(testMAC, 24*time.Hour, false, true, &batch); reason != "" {
+		t.Fatalf("time budget should be off by default, got %q", reason)
+	}
+
+	m = &FactoryManager{Budgets: make(map[ProgressKey]*DeviceBudget)}
+	m.Config.MaxDeviceSeconds = 60
+	if reason := m.chargeBudget(testMAC, 30*time.Second, false, true, &batch); reason != "" {
+		t.Fatalf("30s of 60s: %q", reason)
+	}
+	if reason := m.chargeBudget(testMAC, 40*time.Second, true, false, &batch); reason == "" {
+		t.Fatal("70s of 60s should exhaust the budget")
+	}
+}
+
+// 次數上限同樣預設關閉 (零值 = 不限制)；設定後超過才進入 FAILED
+func TestRetryCountBudget(t *testing.T) {
+	var batch journalBatch
+	for _, limit := range []int{0, -1} {
+		m := &FactoryManager{Budgets: make(map[ProgressKey]*DeviceBudget)}
+		m.Config.MaxReburns, m.Config.MaxReleases = limit, limit
+		for i := 0; i < 20; i++ {
+			if reason := m.chargeBudget(testMAC, time.Second, i%2 == 0, i%2 == 1, &batch); reason != "" {
+				t.Fatalf("limit %d: failure %d exhausted the budget: %q", limit, i+1, reason)
+			}
+		}
+	}
+
+	m := &FactoryManager{Budgets: make(map[ProgressKey]*DeviceBudget)}
+	m.Config.MaxReburns = 2
+	for i := 1; i <= 2; i++ {
+		if reason := m.chargeBudget(testMAC, time.Second, true, false, &batch); reason != "" {
+			t.Fatalf("reburn %d of 2: %q", i, reason)
+		}
+	}
+	if reason := m.chargeBudget(testMAC, time.Second, true, false, &batch); reason == "" {
+		t.Error("third reburn with max_reburns 2 should exhaust the budget")
+	}
+
+	m = &FactoryManager{Budgets: make(map[ProgressKey]*DeviceBudget)}
+	m.Config.MaxReleases = 1
+	if reason := m.chargeBudget(testMAC, time.Second, false, true, &batch); reason != "" {
+		t.Fatalf("release 1 of 1: %q", reason)
+	}
+	if reason := m.chargeBudget(testMAC, time.Second, false, true, &batch); reason == "" {
+		t.Error("second release with max_releases 1 should exhaust the budget")
+	}
+}
+
+// 未開工 (沒有 FactoryManager) 時 LIST_FAILED / RESET_DEVICE 直接操作日誌，涵蓋所有 ADS 雜湊
+func TestBudgetJournalWithoutManager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.jsonl")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const other = "11:22:33:44:55:66"
+	var batch journalBatch
+	batch.add(journalFailed, testMAC, "h1").Reason = "reburns"
+	batch.add(journalReburn, testMAC, "h2")
+	batch.add(journalFailed, other, "h2").Reason = "releases"
+	if err := j.Commit(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Checkpoint(testMAC, "h2", 4096); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	list, err := FailedDevicesInJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].MAC != other || list[1].MAC != testMAC || list[1].Hash != "h1" {
+		t.Fatalf("failed list = %+v", list)
+	}
+
+	if found, err := ResetDeviceInJournal(path, testMAC); err != nil || !found {
+		t.Fatalf("reset: found=%v err=%v", found, err)
+	}
+	if found, err := ResetDeviceInJournal(path, "00:00:00:00:00:00"); err != nil || found {
+		t.Fatalf("reset unknown: found=%v err=%v", found, err)
+	}
+
+	if list, _ = FailedDevicesInJournal(path); len(list) != 1 || list[0].MAC != other {
+		t.Fatalf("after reset = %+v", list)
+	}
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	// 重置只清重試紀錄，燒錄進度保留
+	entries := j.Entries("h2")
+	for _, e := range entries {
+		if e.MAC == testMAC && (e.Reburns != 0 || e.Offset != 4096) {
+			t.Errorf("entry after reset = %+v", e)
+		}
+	}
+}
@@ -1,22 +1,43 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
+
+	"BM2/protocol"
+)
+
+const (
+	ChunkSize        = 192
+	MaxPacketRetries = 5
+
+	// DefaultWindowSize IPC 未指定 window_size 時的視窗大小；已在模擬器 (含丟失 ACK、延遲抖動)
+	// 與 pty Dongle 上測試，設備不穩時 PerformFlash 會自動退回逐包確認
+	DefaultWindowSize = 4
 )
 
+// isLinkError 逾時與 NACK 以外的錯誤 (Port 關閉、連線中斷)：重送沒有意義，應立即結束讓呼叫端續燒
+func isLinkError(err error) bool {
+	return err != nil && !errors.Is(err, ErrACKTimeout) && !errors.Is(err, ErrNACK)
+}
+
 // PerformFlash 依照 Dart Protocol 流程修正
-func PerformFlash(t Transporter, mac string, meta FileMeta, prefix string, offset *int) bool {
+// window > 1 時啟用滑動視窗傳輸，設備不穩時自動退回逐包確認
+// ctx 取消時立即停止；*offset 保留在最後確認的位置，Checksum 仍為 0xFFFF (未完成狀態)
+// checkpoint 不為 nil 時，每回報一次進度 (約 5%) 就以已確認的 Offset 呼叫一次，供續燒日誌存檔
+func PerformFlash(ctx context.Context, t Transporter, mac string, meta FileMeta, prefix string, offset *int, window int, checkpoint func(offset int)) bool {
 	totalSize := len(meta.EncodedData)
 	if totalSize == 0 {
 		return false
 	}
 	currentOffset := *offset
-	var f uint16 = 0
 
 	// 1. 連線
 	reportLog("%s ⏳ 連線中 (Hardware Reset)...\n", prefix)
-	if err := t.Connect(mac); err != nil {
+	if err := t.Connect(ctx, mac); err != nil {
 		reportLog("%s ❌ 連線失敗: %v\n", prefix, err)
 		return false
 	}
@@ -24,43 +45,62 @@ func PerformFlash(t Transporter, mac string, meta FileMeta, prefix string, offse
 	// 2. 解鎖 (Set Operation Mode Engineering)
 	reportLog("%s 🔓 解鎖 (Unlock)...", prefix)
 	t.ResetBuffer()
-	t.SendCmd(0x20, &f, []byte{0xE6, 0x01})
+	fid, _ := SendRequest(t, protocol.Unlock{})
 
 	// 等待 ACK
-	if err := t.WaitForACK(2 * time.Second); err != nil {
+	if err := t.WaitForACK(ctx, fid, 2*time.Second); err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
 		// 嘗試重發一次
 		reportLog("%s ⚠️ 解鎖無回應，重試...\n", prefix)
-		t.SendCmd(0x20, &f, []byte{0xE6, 0x01})
-		if err := t.WaitForACK(2 * time.Second); err != nil {
+		fid, _ = SendRequest(t, protocol.Unlock{})
+		if err := t.WaitForACK(ctx, fid, 2*time.Second); err != nil {
 			reportLog("%s ❌ 解鎖失敗: %v\n", prefix, err)
 			return false
 		}
 	}
-	time.Sleep(200 * time.Millisecond)
+	if sleepCtx(ctx, 200*time.Millisecond) != nil {
+		return false
+	}
 
 	// 🔥 關鍵步驟: 初始化 Checksum (參考 Dart Protocol)
 	// Dart: _writeAudioData(604, 2, [0xff, 0xff])
 	//reportLog("%s 🧹 發送初始化指令 (Write FF to 604)...\n", prefix)
 	t.ResetBuffer()
-	initErr := t.SendAudioChunk(&f, 604, []byte{0xFF, 0xFF})
+	fid, initErr := t.SendAudioChunk(604, []byte{0xFF, 0xFF})
 	if initErr != nil {
 		reportLog("%s ❌ 初始化發送失敗\n", prefix)
 		return false
 	}
 
-	if err := t.WaitForACK(2 * time.Second); err != nil {
+	if err := t.WaitForACK(ctx, fid, 2*time.Second); err != nil {
 		reportLog("%s ⚠️ 初始化指令無回應 (可能未就緒): %v\n", prefix, err)
 		return false
 	}
-	time.Sleep(200 * time.Millisecond)
+	if sleepCtx(ctx, 200*time.Millisecond) != nil {
+		return false
+	}
 
 	// 3. 燒錄
 	reportLog("%s 🔥 開始燒錄 (Total: %d bytes)...", prefix, totalSize)
-	const ChunkSize = 192
 
 	lastPct := -1
 
+	if window > 1 {
+		ok, fallback := flashWindowed(ctx, t, mac, meta, prefix, offset, window, &lastPct, checkpoint)
+		if !fallback {
+			return ok
+		}
+		currentOffset = *offset
+		reportLog("%s ⚠️ 視窗傳輸不穩，改回逐包確認 (Offset %d)\n", prefix, currentOffset)
+	}
+
 	for currentOffset < totalSize {
+		if ctx.Err() != nil {
+			reportLog("%s ⏹️ 燒錄已取消 (Offset %d)\n", prefix, currentOffset)
+			return false
+		}
 		end := currentOffset + ChunkSize
 		if end > totalSize {
 			end = totalSize
@@ -71,60 +111,196 @@ func PerformFlash(t Transporter, mac string, meta FileMeta, prefix string, offse
 		// 單包重試機制
 		packetSuccess := false
 		packetRetries := 0
-		const MaxPacketRetries = 5
 
 		for packetRetries < MaxPacketRetries {
 			t.ResetBuffer()
 
-			err := t.SendAudioChunk(&f, currentOffset, chunkData)
+			chunkFid, err := t.SendAudioChunk(currentOffset, chunkData)
 			if err != nil {
 				return false
 			}
 
-			ackErr := t.WaitForACK(1500 * time.Millisecond)
+			// 只接受本次封包 ID 的 ACK，避免上一包的遲到 ACK 被誤認
+			ackErr := t.WaitForACK(ctx, chunkFid, 1500*time.Millisecond)
 
 			if ackErr == nil {
 				packetSuccess = true
 				break
+			} else if isLinkError(ackErr) && ctx.Err() == nil {
+				reportLog("%s ❌ 連線中斷 (Offset %d): %v\n", prefix, currentOffset, ackErr)
+				return false
 			} else {
 				packetRetries++
 				if packetRetries >= 2 {
 					reportLog("%s ⚠️ Offset %d ACK 超時，重傳 (%d/%d)...\n", prefix, currentOffset, packetRetries, MaxPacketRetries)
 				}
-				time.Sleep(200 * time.Millisecond)
+				if sleepCtx(ctx, 200*time.Millisecond) != nil {
+					break
+				}
 			}
 		}
 
 		if !packetSuccess {
+			if ctx.Err() != nil {
+				reportLog("%s ⏹️ 燒錄已取消 (Offset %d)\n", prefix, currentOffset)
+				return false
+			}
 			reportLog("%s ❌ 燒錄失敗：Offset %d 連續無回應\n", prefix, currentOffset)
 			return false
 		}
 
 		currentOffset += (end - currentOffset)
 		*offset = currentOffset
+		if reportFlashProgress(mac, meta.SHA256, prefix, currentOffset, totalSize, &lastPct) && checkpoint != nil {
+			checkpoint(currentOffset)
+		}
+
+		sleepCtx(ctx, 50*time.Millisecond)
+	}
+	return true
+}
+
+// reportFlashProgress 每 5% 回報一次；有回報時回傳 true
+func reportFlashProgress(mac, hash, prefix string, currentOffset, totalSize int, lastPct *int) bool {
+	pct := int(float64(currentOffset) / float64(totalSize) * 100)
+	if (pct > *lastPct && pct%5 == 0) || currentOffset == totalSize {
+		reportProgress(mac, hash, pct)
+		reportLog("LOG:%s ⏳ 進度: %d%% (%d/%d)\n", prefix, pct, currentOffset, totalSize)
+		*lastPct = pct
+		return true
+	}
+	return false
+}
+
+// flashWindowed 滑動視窗傳輸：同時送出 window 包，依 Frame ID 追蹤 ACK，只重送未確認的 Offset
+// *offset 只會推進到「連續已確認」的位置，因此中斷後的續燒仍然安全
+// 回傳 fallback = true 代表同一包重送過多次，呼叫端應改用逐包確認
+func flashWindowed(ctx context.Context, t Transporter, mac string, meta FileMeta, prefix string, offset *int, window int, lastPct *int, checkpoint func(offset int)) (ok bool, fallback bool) {
+	type chunk struct {
+		start, end int
+		deadline   time.Time
+		retries    int
+	}
+	const ackTimeout = 1500 * time.Millisecond
+	const maxWindowRetries = 2
+
+	totalSize := len(meta.EncodedData)
+	base := *offset
+	next := base
+	inflight := make(map[uint16]*chunk)
+	acked := make(map[int]int) // start -> end
+
+	send := func(c *chunk) bool {
+		fid, err := t.SendAudioChunk(c.start, meta.EncodedData[c.start:c.end])
+		if err != nil {
+			return false
+		}
+		c.deadline = time.Now().Add(ackTimeout)
+		inflight[fid] = c
+		return true
+	}
+	retransmit := func(c *chunk) (bool, bool) {
+		c.retries++
+		if c.retries > maxWindowRetries {
+			return false, true
+		}
+		reportLog("%s ⚠️ Offset %d 未確認，重傳 (%d/%d)...\n", prefix, c.start, c.retries, maxWindowRetries)
+		return send(c), false
+	}
+
+	t.ResetBuffer()
+	for base < totalSize {
+		if ctx.Err() != nil {
+			reportLog("%s ⏹️ 燒錄已取消 (Offset %d)\n", prefix, base)
+			return false, false
+		}
+		for len(inflight) < window && next < totalSize {
+			end := next + ChunkSize
+			if end > totalSize {
+				end = totalSize
+			}
+			if !send(&chunk{start: next, end: end}) {
+				return false, false
+			}
+			next = end
+		}
+
+		earliest := time.Time{}
+		for _, c := range inflight {
+			if earliest.IsZero() || c.deadline.Before(earliest) {
+				earliest = c.deadline
+			}
+		}
 
-		pct := int(float64(currentOffset) / float64(totalSize) * 100)
-		if (pct > lastPct && pct%5 == 0) || currentOffset == totalSize {
+		f, err := t.ReadFrame(ctx, time.Until(earliest))
+		if err == nil {
+			c, found := inflight[f.FID]
+			if !found || f.Kind == FrameData {
+				// 已被重傳取代的舊 Frame ID 或無關封包
+				continue
+			}
+			delete(inflight, f.FID)
+			if f.Kind == FrameNACK {
+				sent, fb := retransmit(c)
+				if !sent {
+					return false, fb
+				}
+				continue
+			}
+
+			acked[c.start] = c.end
+			for {
+				end, done := acked[base]
+				if !done {
+					break
+				}
+				delete(acked, base)
+				base = end
+			}
+			*offset = base
+			if reportFlashProgress(mac, meta.SHA256, prefix, base, totalSize, lastPct) && checkpoint != nil {
+				checkpoint(base)
+			}
+			continue
+		}
 
-			reportProgress(mac, pct)
-			reportLog("LOG:%s ⏳ 進度: %d%% (%d/%d)\n", prefix, pct, currentOffset, totalSize)
-			lastPct = pct
+		if ctx.Err() != nil {
+			continue
+		}
+		if isLinkError(err) {
+			reportLog("%s ❌ 連線中斷 (Offset %d): %v\n", prefix, base, err)
+			return false, false
 		}
 
-		time.Sleep(50 * time.Millisecond)
+		// 逾時：依 Offset 順序重送所有過期的包
+		now := time.Now()
+		var expired []uint16
+		for fid, c := range inflight {
+			if !now.Before(c.deadline) {
+				expired = append(expired, fid)
+			}
+		}
+		sort.Slice(expired, func(i, j int) bool { return inflight[expired[i]].start < inflight[expired[j]].start })
+		for _, fid := range expired {
+			c := inflight[fid]
+			delete(inflight, fid)
+			sent, fb := retransmit(c)
+			if !sent {
+				return false, fb
+			}
+		}
 	}
-	return true
+	return true, false
 }
 
-func VerifyChecksumAndReboot(t Transporter, meta FileMeta, prefix string) bool {
-	var f uint16 = 0
+func VerifyChecksumAndReboot(ctx context.Context, t Transporter, meta FileMeta, prefix string) bool {
 	fmt.Printf("%s 🔐 Checksum 驗證中...\n", prefix)
 
 	// 發送 604 與 605 位置的真實校驗碼
 	chkBytes := meta.RawData[604:606]
-	t.SendAudioChunk(&f, 604, chkBytes)
+	fid, _ := t.SendAudioChunk(604, chkBytes)
 
-	if err := t.WaitForACK(3 * time.Second); err != nil {
+	if err := t.WaitForACK(ctx, fid, 3*time.Second); err != nil {
 		fmt.Printf("%s ❌ Checksum 失敗\n", prefix)
 		return false
 	}
@@ -132,8 +308,10 @@ func VerifyChecksumAndReboot(t Transporter, meta FileMeta, prefix string) bool {
 	// 下達重啟 (OpCode 0xE4) 指令 3 次
 	fmt.Printf("%s 🔄 發送重啟指令...\n", prefix)
 	for k := 0; k < 3; k++ {
-		t.SendCmd(0x20, &f, []byte{0xE4, 0x00, 0x01})
-		time.Sleep(200 * time.Millisecond)
+		SendRequest(t, protocol.Reboot{})
+		if sleepCtx(ctx, 200*time.Millisecond) != nil {
+			return false
+		}
 	}
 	return true
 }
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// ==========================================
+// 命令列子指令 (不帶參數時仍為 Flutter IPC 模式)
+// ==========================================
+
+func runCommand(name string, args []string) int {
+	// Ctrl+C 會取消進行中的流程
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch name {
+	case "selftest":
+		return cmdSelfTest(ctx, args)
+	case "emulate-dongle":
+		return cmdEmulateDongle(ctx, args)
+	case "replay":
+		return cmdReplay(ctx, args)
+	case "ports":
+		return cmdPorts()
+	case "build-ads":
+		return cmdBuildADS(args)
+	case "validate-ads":
+		return cmdValidateADS(args)
+	case "extract-ads":
+		return cmdExtractADS(args)
+	case "diff-ads":
+		return cmdDiffADS(args)
+	case "backup":
+		return cmdBackup(ctx, args)
+	case "history":
+		return cmdHistory(args)
+	}
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n", name)
+	return 2
+}
+
+// cmdSelfTest 以模擬安全帽跑完整的 燒錄 → Checksum/重啟 → 比對 流程
+func cmdSelfTest(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	adsPath := fs.String("ads", "", "ADS 檔案 (留空則使用內建測試映像)")
+	dropRate := fs.Float64("drop-ack", 0, "ACK 丟失機率 (0~1)")
+	corruptRate := fs.Float64("corrupt-read", 0, "讀取資料竄改機率 (0~1)")
+	delay := fs.Duration("delay", 0, "每個回應的延遲")
+	jitter := fs.Duration("jitter", 0, "回應的隨機延遲上限")
+	disconnectAfter := fs.Int("disconnect-after", 0, "寫入 N 包後斷線 (0 = 不斷線)")
+	connectFailures := fs.Int("connect-failures", 0, "前 N 次連線失敗")
+	traceDir := fs.String("trace", "", "錄製 Trace 檔的目錄")
+	window := fs.Int("window", DefaultWindowSize, "滑動視窗大小 (1 = 逐包確認)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	meta, err := loadCLIImage(*adsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: %v\n", err)
+		return 1
+	}
+	if len(meta.EncodedData) == 0 {
+		fmt.Fprintln(os.Stderr, "selftest: invalid ADS")
+		return 1
+	}
+
+	const mac = "EE:EE:EE:00:00:01"
+	helmet := NewEmulatedHelmet(mac, len(meta.EncodedData), EmulatorFaults{
+		DropACKRate:           *dropRate,
+		CorruptReadRate:       *corruptRate,
+		ResponseDelay:         *delay,
+		ResponseJitter:        *jitter,
+		DisconnectAfterWrites: *disconnectAfter,
+		ConnectFailures:       *connectFailures,
+	})
+	var t Transporter = NewEmulatedTransport(helmet)
+	if *traceDir != "" {
+		rec, err := NewTraceRecorder(t, *traceDir, "EMU", mac)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "selftest: %v\n", err)
+			return 1
+		}
+		defer rec.Close()
+		t = rec
+	}
+	prefix := "[EMU]"
+
+	// 與 RunWorker 相同：燒錄失敗時保留 Offset，重新連線後接續
+	offset := 0
+	flashed := false
+	for attempt := 0; attempt < 3 && !flashed; attempt++ {
+		flashed = PerformFlash(ctx, t, mac, meta, prefix, &offset, *window, nil)
+	}
+	if !flashed {
+		fmt.Fprintln(os.Stderr, "selftest: flash failed")
+		return 1
+	}
+	if !VerifyChecksumAndReboot(ctx, t, meta, prefix) {
+		fmt.Fprintln(os.Stderr, "selftest: checksum/reboot failed")
+		return 1
+	}
+	t.Disconnect()
+
+	if err := t.Connect(ctx, mac); err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: reconnect failed: %v\n", err)
+		return 1
+	}
+	match, err := PerformFinalDebugCheck(ctx, t, meta, prefix)
+	t.Disconnect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: verify error: %v\n", err)
+		return 1
+	}
+	if !match {
+		fmt.Fprintln(os.Stderr, "selftest: verify mismatch")
+		return 1
+	}
+	writes, reboots := helmet.Counts()
+	fmt.Printf("selftest: OK (%d bytes, %d writes, %d reboots)\n", len(meta.EncodedData), writes, reboots)
+	return 0
+}
+
+// cmdReplay 以 Trace 檔重播 PerformFlash 或 performPagedRead
+func cmdReplay(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	tracePath := fs.String("trace", "", "Trace 檔 (.trace.jsonl)")
+	adsPath := fs.String("ads", "", "flash 模式使用的 ADS 檔案 (留空則使用內建測試映像)")
+	mode := fs.String("mode", "flash", "flash 或 read")
+	offset := fs.Int("offset", 0, "flash 模式的起始 Offset")
+	window := fs.Int("window", DefaultWindowSize, "錄製時使用的滑動視窗大小")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	events, err := LoadTrace(*tracePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	if len(events) == 0 {
+		fmt.Fprintln(os.Stderr, "replay: empty trace")
+		return 1
+	}
+
+	rt := NewReplayTransport(events)
+	prefix := "[REPLAY]"
+	ok := false
+	switch *mode {
+	case "flash":
+		meta, err := loadCLIImage(*adsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 1
+		}
+		ok = PerformFlash(ctx, rt, events[0].MAC, meta, prefix, offset, *window, nil)
+		fmt.Printf("replay: flash=%v offset=%d\n", ok, *offset)
+	case "read":
+		tracks := performPagedRead(ctx, rt, prefix)
+		ok = tracks != nil
+		fmt.Printf("replay: read=%v tracks=%d\n", ok, len(tracks))
+	default:
+		fmt.Fprintf(os.Stderr, "replay: unknown mode %q\n", *mode)
+		return 2
+	}
+
+	for _, d := range rt.Divergences {
+		fmt.Printf("replay: divergence %s\n", d)
+	}
+	if !ok || len(rt.Divergences) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// cmdPorts 列出偵測到的 CP210x Dongle
+func cmdPorts() int {
+	dongles, err := EnumerateDongles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ports: %v\n", err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(dongles)
+	return 0
+}
+
+// cmdBuildADS 依 Manifest 將 WAV 檔組成 ADS 映像，並在旁邊輸出 <out>.report.json
+func cmdBuildADS(args []string) int {
+	fs := flag.NewFlagSet("build-ads", flag.ContinueOnError)
+	manifest := fs.String("manifest", "", "Manifest JSON：{\"<Track ID>\": \"<WAV 路徑>\"}")
+	out := fs.String("o", "out.ads", "輸出的 ADS 檔案")
+	var opts AudioOptions
+	fs.IntVar(&opts.SampleRate, "rate", 0, "目標取樣率 (Hz)；0 = 不檢查也不轉換取樣率")
+	fs.BoolVar(&opts.Convert, "convert", false, "自動轉換非 16-bit / 多聲道 / 取樣率不符的 WAV")
+	fs.BoolVar(&opts.Strict, "strict", false, "不轉換時拒絕不符規格的 WAV (預設只警告)")
+	fs.Float64Var(&opts.NormalizeDBFS, "normalize", 0, "RMS 正規化目標 dBFS (例如 -16；0 = 不調整)")
+	fs.BoolVar(&opts.TrimSilence, "trim", false, "裁掉頭尾靜音")
+	fs.Float64Var(&opts.SilenceDBFS, "silence", defaultSilenceDBFS, "靜音門檻 dBFS")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *manifest == "" {
+		fmt.Fprintln(os.Stderr, "build-ads: -manifest is required")
+		return 2
+	}
+
+	image, reports, err := BuildADSFromManifest(*manifest, opts)
+	if reports != nil {
+		reportPath := strings.TrimSuffix(*out, filepath.Ext(*out)) + ".report.json"
+		if data, jerr := json.MarshalIndent(reports, "", "  "); jerr == nil {
+			os.WriteFile(reportPath, data, 0o644)
+		}
+		for _, r := range reports {
+			switch {
+			case r.Error != "":
+				fmt.Printf("❌ %d %s: %s\n", r.ID, r.File, r.Error)
+			case len(r.Warnings) > 0:
+				fmt.Printf("⚠️ %d %s: %s\n", r.ID, r.File, strings.Join(r.Warnings, "; "))
+			}
+		}
+		fmt.Printf("build-ads: report %s\n", reportPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "build-ads: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*out, image, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "build-ads: %v\n", err)
+		return 1
+	}
+	meta := ParseADSBytes(image)
+	fmt.Printf("build-ads: %s (%d bytes, %d tracks)\n", *out, len(image), len(meta.Tracks))
+	return 0
+}
+
+// cmdValidateADS 驗證 ADS 檔案；有 error 時結束碼為 1
+func cmdValidateADS(args []string) int {
+	fs := flag.NewFlagSet("validate-ads", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "以 JSON 輸出")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: validate-ads [-json] <file.ads>")
+		return 2
+	}
+	issues, err := ValidateADSFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "validate-ads: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		if issues == nil {
+			issues = []ADSIssue{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(issues)
+	} else {
+		for _, i := range issues {
+			fmt.Println(i)
+		}
+		if len(issues) == 0 {
+			fmt.Println("validate-ads: OK")
+		}
+	}
+	if hasADSErrors(issues) {
+		return 1
+	}
+	return 0
+}
+
+// cmdExtractADS 將 ADS 的每個音軌匯出為 <Track ID>.wav (重複的 ID 加上序號)
+func cmdExtractADS(args []string) int {
+	fs := flag.NewFlagSet("extract-ads", flag.ContinueOnError)
+	outDir := fs.String("o", ".", "輸出目錄")
+	encoded := fs.Bool("encoded", false, "映像是從安全帽讀回的 (還原 +0x80)")
+	rate := fs.Int("rate", DefaultSampleRate, "WAV 取樣率 (Hz)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: extract-ads [-o dir] [-encoded] [-rate hz] <file.ads>")
+		return 2
+	}
+	image, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "extract-ads: %v\n", err)
+		return 1
+	}
+	tracks, err := ExtractADSTracks(image, *encoded)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "extract-ads: %v\n", err)
+		return 1
+	}
+	paths, err := WriteTrackWAVs(tracks, *outDir, *rate)
+	for _, p := range paths {
+		fmt.Println(p)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "extract-ads: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// cmdDiffADS 逐軌比對兩個 ADS；有差異時結束碼為 1 (同 diff)
+func cmdDiffADS(args []string) int {
+	fs := flag.NewFlagSet("diff-ads", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "以 JSON 輸出")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: diff-ads [-json] <old.ads> <new.ads>")
+		return 2
+	}
+	// 解析過程的輸出改到 stderr，讓 stdout 只有報告
+	oldMeta, newMeta := ParseADSFileTo(os.Stderr, fs.Arg(0)), ParseADSFileTo(os.Stderr, fs.Arg(1))
+	if oldMeta.RawData == nil || newMeta.RawData == nil {
+		fmt.Fprintln(os.Stderr, "diff-ads: failed to parse input")
+		return 2
+	}
+
+	diff := DiffADS(fs.Arg(0), oldMeta, fs.Arg(1), newMeta)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(diff)
+	} else {
+		diff.WriteText(os.Stdout)
+	}
+	if len(diff.Changes) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// cmdBackup 讀回設備的完整音訊區並存成 .ads
+func cmdBackup(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	port := fs.String("port", "", "Dongle Port (COMx、tcp://host:port 或 BLE:n)")
+	mac := fs.String("mac", "", "安全帽 MAC")
+	out := fs.String("o", "", "輸出的 ADS 檔案 (預設 <MAC>.ads)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *port == "" || *mac == "" {
+		fmt.Fprintln(os.Stderr, "usage: backup -port <port> -mac <mac> [-o file.ads]")
+		return 2
+	}
+	if *out == "" {
+		*out = strings.ReplaceAll(*mac, ":", "") + ".ads"
+	}
+	n, err := BackupToFile(ctx, *port, *mac, *out, defaultBLEConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	fmt.Printf("backup: %s (%d bytes)\n", *out, n)
+	return 0
+}
+
+// cmdHistory 查詢燒錄履歷，輸出 JSON 或 CSV
+func cmdHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	db := fs.String("db", "", "履歷檔 (預設為使用者設定目錄下的 BeeMaster/history.jsonl)")
+	mac := fs.String("mac", "", "只列出此 MAC")
+	dasID := fs.String("dasid", "", "只列出此 DasID")
+	from := fs.String("from", "", "起始日期 (2006-01-02 或 RFC3339)")
+	to := fs.String("to", "", "結束日期 (含當天)")
+	format := fs.String("format", "json", "輸出格式 json 或 csv")
+	out := fs.String("o", "", "輸出檔案 (預設 stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	records, err := queryHistory(Order{History: *db, MAC: *mac, DasID: *dasID, From: *from, To: *to})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	if *out != "" {
+		err = exportHistoryFile(*out, records, *format)
+	} else {
+		err = ExportHistory(os.Stdout, records, *format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	if *out != "" {
+		fmt.Printf("history: %s (%d records)\n", *out, len(records))
+	}
+	return 0
+}
+
+// loadCLIImage 讀取 -ads 指定的檔案；未指定時使用內建的示範映像 (3 軌，約 9KB)
+func loadCLIImage(path string) (FileMeta, error) {
+	if path != "" {
+		return ParseADSFile(path), nil
+	}
+	var tracks []ADSTrack
+	for _, t := range []struct{ id, size int }{{1, 4096}, {2, 3000}, {3, 1536}} {
+		pcm := make([]byte, t.size)
+		for j := range pcm {
+			pcm[j] = byte((t.id*31 + j) & 0xff)
+		}
+		tracks = append(tracks, ADSTrack{ID: uint32(t.id), PCM: pcm})
+	}
+	image, err := BuildADS(tracks)
+	if err != nil {
+		return FileMeta{}, fmt.Errorf("builtin ADS: %w", err)
+	}
+	return ParseADSBytes(image), nil
+}
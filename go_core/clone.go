@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==========================================
+// 複製模式 (Golden Helmet)
+// 以一頂已部署的安全帽為來源：完整讀回、驗證後作為本次工作的 FileMeta
+// ==========================================
+
+const cloneAttempts = 3
+
+// isCloneSource 來源安全帽不會被排入燒錄
+func (m *FactoryManager) isCloneSource(mac string) bool {
+	return m.Config.CloneFrom != "" && strings.EqualFold(mac, m.Config.CloneFrom)
+}
+
+// loadCloneSource 借用一個 Port 讀回來源設備；驗證失敗或取消時回傳 false，工作不會開始
+func (m *FactoryManager) loadCloneSource() bool {
+	src := m.Config.CloneFrom
+	sendLog("SYSTEM", fmt.Sprintf("🧬 複製模式：讀取來源設備 %s...", src))
+
+	var image []byte
+	for attempt := 1; attempt <= cloneAttempts && image == nil; attempt++ {
+		port, ok := m.nextPort()
+		if !ok {
+			return false
+		}
+		// 來源必須是燒錄完成的設備：Checksum 為 0xFFFF 或與 Header 不符時不可作為母片
+		data, err := backupFromPort(m.ctx, port, src, fmt.Sprintf("[%s][CLONE]", port), m.BLE, true)
+		m.releasePort(port)
+		if m.ctx.Err() != nil {
+			return false
+		}
+		if err != nil {
+			sendLog(port, fmt.Sprintf("⚠️ 讀取來源失敗 (%d/%d): %v", attempt, cloneAttempts, err))
+			continue
+		}
+		image = data
+	}
+	if image == nil {
+		sendError("SYSTEM", fmt.Sprintf("無法讀取來源設備 %s，複製模式未啟動", src))
+		return false
+	}
+
+	if !reportADSIssues(ValidateADS(image)) {
+		return false
+	}
+	// LIST_FAILED 等 IPC 指令會在 MapMutex 下讀取 Meta，須在鎖內替換
+	meta := ParseADSBytes(image)
+	m.MapMutex.Lock()
+	m.Meta = meta
+	m.MapMutex.Unlock()
+	sendLog("SYSTEM", fmt.Sprintf("🧬 來源讀取完成 (%d bytes, %d tracks)，開始燒錄目標", len(image), len(meta.Tracks)))
+	return true
+}
@@ -0,0 +1,411 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"BM2/protocol"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+// ==========================================
+// 序列埠層級的 Dongle 模擬器 (Linux pty)
+// 讓未修改的 SerialAdaptor 直接對 /dev/pts/N 做端對端測試
+//
+// pty 沒有 DTR/RTS 線路：第一個模擬器啟動時替換 openSerial，開啟模擬器的 pty 時包一層 emulatedLinePort，
+// 把 SetDTR/SetRTS 轉給模擬器 (旁路通道)；DTR/RTS 由低轉高即視為 Dongle 重置。
+// ResetBuffer 造成的 flush 則以 TIOCPKT 觀察。
+// ==========================================
+
+// ptyEmulators 以 Slave 路徑登記執行中的模擬器，供替換後的 openSerial 接上 DTR/RTS 旁路
+var (
+	ptyEmulators     sync.Map
+	installPtyOpener sync.Once
+)
+
+type dongleState int
+
+const (
+	dongleScanning    dongleState = iota
+	dongleIdle                    // 0x83 停止掃描後
+	dongleLinked                  // 0x85 連線成功 (指令模式)
+	donglePassthrough             // 0x21 切換後，0x20 封包轉送給安全帽
+)
+
+// DongleEmulator 在 pty master 端扮演 CP210x Dongle
+type DongleEmulator struct {
+	SlavePath string
+	Helmets   map[string]*EmulatedHelmet // key: protocol.ParseMAC 的結果
+
+	master  *os.File
+	slaveFd int
+
+	// BootTime 重置後的開機時間，期間收到的指令一律忽略 (與實機相同)
+	BootTime time.Duration
+	// Legacy 舊版韌體：Stop Scan 與模式切換都不回 ACK
+	Legacy bool
+	// RejectConnect 故障注入：以 NACK 拒絕 0x85 連線指令
+	RejectConnect bool
+
+	mu        sync.Mutex
+	state     dongleState
+	linked    *EmulatedHelmet
+	flushes   int
+	resets    int
+	dtr, rts  bool
+	lowered   bool // DTR/RTS 都曾拉低，等待轉高
+	bootUntil time.Time
+	closed    chan struct{}
+}
+
+// NewDongleEmulator 開啟一組 pty 並回傳模擬器；SlavePath 即為給 SerialAdaptor 的 Port 名稱
+func NewDongleEmulator(helmets ...*EmulatedHelmet) (*DongleEmulator, error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open ptmx: %w", err)
+	}
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("unlockpt: %w", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("ptsname: %w", err)
+	}
+	// 封包模式：Slave 端 tcflush 會以控制位元組通知 Master
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCPKT, 1); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("TIOCPKT: %w", err)
+	}
+
+	slavePath := fmt.Sprintf("/dev/pts/%d", n)
+	// 模擬器自己保留一個 Slave fd：避免客戶端關閉時 Master 讀到 EIO，並先設成 raw 防止回顯
+	slaveFd, err := unix.Open(slavePath, unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("open %s: %w", slavePath, err)
+	}
+	if tio, err := unix.IoctlGetTermios(slaveFd, unix.TCGETS); err == nil {
+		tio.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		tio.Oflag &^= unix.OPOST
+		tio.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		tio.Cflag &^= unix.CSIZE | unix.PARENB
+		tio.Cflag |= unix.CS8
+		unix.IoctlSetTermios(slaveFd, unix.TCSETS, tio)
+	}
+
+	d := &DongleEmulator{
+		SlavePath: slavePath,
+		Helmets:   make(map[string]*EmulatedHelmet),
+		master:    os.NewFile(uintptr(fd), "/dev/ptmx"),
+		slaveFd:   slaveFd,
+		closed:    make(chan struct{}),
+	}
+	for _, h := range helmets {
+		mac, err := protocol.ParseMAC(h.MAC)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.Helmets[string(mac[:])] = h
+	}
+	installPtyOpener.Do(func() {
+		open := openSerial
+		openSerial = func(name string, mode *serial.Mode) (serial.Port, error) {
+			port, err := open(name, mode)
+			if err != nil {
+				return nil, err
+			}
+			return wrapEmulatedPort(name, port), nil
+		}
+	})
+	ptyEmulators.Store(slavePath, d)
+	go d.serve()
+	return d, nil
+}
+
+func (d *DongleEmulator) Close() error {
+	select {
+	case <-d.closed:
+		return nil
+	default:
+	}
+	close(d.closed)
+	ptyEmulators.Delete(d.SlavePath)
+	unix.Close(d.slaveFd)
+	return d.master.Close()
+}
+
+// Flushes 回傳觀察到的 ResetBuffer (tcflush) 次數
+func (d *DongleEmulator) Flushes() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flushes
+}
+
+// Resets 回傳觀察到的 DTR/RTS 重置次數
+func (d *DongleEmulator) Resets() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resets
+}
+
+// setLines DTR/RTS 旁路：兩條線由低轉高時重置 Dongle。
+// 尚未連線時重新開機 (回到掃描狀態並忽略開機期間的指令)；
+// 已連線時只離開透傳模式，等待 0x21 (對應 Connect 的 Reset 2)
+func (d *DongleEmulator) setLines(dtr, rts bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dtr, d.rts = dtr, rts
+	if !dtr && !rts {
+		d.lowered = true
+		return
+	}
+	if !d.lowered || !dtr || !rts {
+		return
+	}
+	d.lowered = false
+	d.resets++
+	if d.linked != nil {
+		d.state = dongleLinked
+		return
+	}
+	d.state = dongleScanning
+	d.bootUntil = time.Now().Add(d.BootTime)
+}
+
+// emulatedLinePort 將 DTR/RTS 轉給模擬器，其餘操作直接使用 pty
+type emulatedLinePort struct {
+	serial.Port
+	d *DongleEmulator
+}
+
+func (p *emulatedLinePort) SetDTR(dtr bool) error {
+	p.d.mu.Lock()
+	rts := p.d.rts
+	p.d.mu.Unlock()
+	p.d.setLines(dtr, rts)
+	return nil
+}
+
+func (p *emulatedLinePort) SetRTS(rts bool) error {
+	p.d.mu.Lock()
+	dtr := p.d.dtr
+	p.d.mu.Unlock()
+	p.d.setLines(dtr, rts)
+	return nil
+}
+
+// wrapEmulatedPort 開啟的是模擬器的 pty 時接上 DTR/RTS 旁路
+func wrapEmulatedPort(name string, port serial.Port) serial.Port {
+	if d, ok := ptyEmulators.Load(name); ok {
+		return &emulatedLinePort{Port: port, d: d.(*DongleEmulator)}
+	}
+	return port
+}
+
+func (d *DongleEmulator) serve() {
+	var decoder FrameDecoder
+	buf := make([]byte, 4096)
+	for {
+		n, err := d.master.Read(buf)
+		if err != nil {
+			select {
+			case <-d.closed:
+				return
+			default:
+			}
+			// 客戶端尚未開啟或剛關閉時可能短暫讀到錯誤
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		// 封包模式第一個 byte 為控制位元組：0 代表後面是資料
+		if buf[0] != unix.TIOCPKT_DATA {
+			if buf[0]&(unix.TIOCPKT_FLUSHREAD|unix.TIOCPKT_FLUSHWRITE) != 0 {
+				d.mu.Lock()
+				d.flushes++
+				d.mu.Unlock()
+				decoder.Reset()
+			}
+			continue
+		}
+		decoder.Feed(buf[1:n])
+		for {
+			f, ok := decoder.Next()
+			if !ok {
+				break
+			}
+			d.handle(f)
+		}
+	}
+}
+
+func (d *DongleEmulator) handle(f Frame) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Now().Before(d.bootUntil) {
+		return
+	}
+
+	switch f.Target {
+	case protocol.TargetDongle:
+		if len(f.Payload) == 0 {
+			return
+		}
+		switch f.Payload[0] {
+		case protocol.OpStopScan:
+			d.state = dongleIdle
+			d.linked = nil
+			if !d.Legacy {
+				d.reply(f.Target, f.FID, 0, f.Payload[:1], 0)
+			}
+		case protocol.OpConnect:
+			// 實機沒有已知的連線狀態回報：只建立連線，不回應 (故障注入時回 NACK)
+			if d.RejectConnect {
+				d.reply(f.Target, f.FID, 1, f.Payload[:1], 0)
+				return
+			}
+			c, err := protocol.DecodeConnect(f.Payload)
+			h := d.Helmets[string(c.MAC[:])]
+			if err != nil || h == nil || h.accept() != nil {
+				return
+			}
+			h.reconnect()
+			d.linked = h
+			d.state = dongleLinked
+		}
+
+	case protocol.TargetMode:
+		if d.linked == nil {
+			if !d.Legacy {
+				d.reply(f.Target, f.FID, 1, f.Payload[:min(1, len(f.Payload))], 0)
+			}
+			return
+		}
+		d.state = donglePassthrough
+		if d.Legacy {
+			return
+		}
+		d.reply(f.Target, f.FID, 0, f.Payload[:min(1, len(f.Payload))], 0)
+
+	case protocol.TargetHelmet:
+		if d.state != donglePassthrough || d.linked == nil {
+			d.reply(f.Target, f.FID, 1, f.Payload[:min(1, len(f.Payload))], 0)
+			return
+		}
+		replies, err := d.linked.Handle(f.Target, f.FID, f.Payload)
+		if err != nil {
+			// 與安全帽的連線中斷：Dongle 回到閒置狀態，不再回應
+			d.state = dongleIdle
+			d.linked = nil
+			return
+		}
+		delay := d.linked.delay()
+		for _, r := range replies {
+			d.reply(r.Target, r.FID, r.Status, r.Payload, delay)
+		}
+	}
+}
+
+// reply 寫回一個封包 (呼叫端須持有 d.mu)
+func (d *DongleEmulator) reply(target byte, fid uint16, status uint16, payload []byte, delay time.Duration) {
+	packet := buildFrame(target, fid, status, payload)
+	if delay <= 0 {
+		d.master.Write(packet)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		select {
+		case <-d.closed:
+		default:
+			d.master.Write(packet)
+		}
+	})
+}
+
+// cmdEmulateDongle 啟動 pty Dongle 模擬器；加上 -selftest 時直接以 SerialAdaptor 跑完整流程
+func cmdEmulateDongle(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("emulate-dongle", flag.ContinueOnError)
+	mac := fs.String("mac", "EE:EE:EE:00:00:01", "模擬安全帽的 MAC")
+	size := fs.Int("size", 1<<20, "模擬 Flash 大小 (bytes)")
+	adsPath := fs.String("ads", "", "-selftest 使用的 ADS 檔案 (留空則使用內建測試映像)")
+	selfTest := fs.Bool("selftest", false, "以 SerialAdaptor 對模擬器跑燒錄/驗證流程後結束")
+	window := fs.Int("window", DefaultWindowSize, "-selftest 的滑動視窗大小")
+	dropRate := fs.Float64("drop-ack", 0, "ACK 丟失機率 (0~1)")
+	corruptRate := fs.Float64("corrupt-read", 0, "讀取資料竄改機率 (0~1)")
+	delay := fs.Duration("delay", 0, "每個回應的延遲")
+	boot := fs.Duration("boot", 300*time.Millisecond, "DTR/RTS 重置後的開機時間")
+	legacy := fs.Bool("legacy", false, "模擬不回 ACK 的舊版 Dongle 韌體")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	helmet := NewEmulatedHelmet(*mac, *size, EmulatorFaults{
+		DropACKRate:     *dropRate,
+		CorruptReadRate: *corruptRate,
+		ResponseDelay:   *delay,
+	})
+	d, err := NewDongleEmulator(helmet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "emulate-dongle: %v\n", err)
+		return 1
+	}
+	defer d.Close()
+	d.BootTime = *boot
+	d.Legacy = *legacy
+
+	if !*selfTest {
+		fmt.Printf("PTY: %s\n", d.SlavePath)
+		<-ctx.Done()
+		return 0
+	}
+
+	meta, err := loadCLIImage(*adsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "emulate-dongle: %v\n", err)
+		return 1
+	}
+	if len(meta.EncodedData) == 0 || len(meta.EncodedData) > *size {
+		fmt.Fprintln(os.Stderr, "emulate-dongle: invalid ADS")
+		return 1
+	}
+
+	t := NewSerialAdaptor(d.SlavePath)
+	prefix := "[PTY]"
+	offset := 0
+	if !PerformFlash(ctx, t, *mac, meta, prefix, &offset, *window, nil) {
+		fmt.Fprintln(os.Stderr, "emulate-dongle: flash failed")
+		return 1
+	}
+	if !VerifyChecksumAndReboot(ctx, t, meta, prefix) {
+		fmt.Fprintln(os.Stderr, "emulate-dongle: checksum/reboot failed")
+		return 1
+	}
+	t.Disconnect()
+
+	if err := t.Connect(ctx, *mac); err != nil {
+		fmt.Fprintf(os.Stderr, "emulate-dongle: reconnect failed: %v\n", err)
+		return 1
+	}
+	match, err := PerformFinalDebugCheck(ctx, t, meta, prefix)
+	t.Disconnect()
+	if err != nil || !match {
+		fmt.Fprintf(os.Stderr, "emulate-dongle: verify failed (match=%v, err=%v)\n", match, err)
+		return 1
+	}
+	writes, reboots := helmet.Counts()
+	fmt.Printf("emulate-dongle: OK (%d writes, %d reboots, %d flushes, %d resets)\n", writes, reboots, d.Flushes(), d.Resets())
+	return 0
+}
@@ -0,0 +1,245 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"BM2/protocol"
+)
+
+// 未修改的 SerialAdaptor 透過 pty 連線：DTR/RTS 重置、開機期間丟失指令、透傳後的安全帽 ACK；
+// 同時錄製原始 Trace，確認連線流程的封包有被記錄且可以重播
+func TestSerialAdaptorAgainstPtyDongle(t *testing.T) {
+	helmet := NewEmulatedHelmet(testMAC, 4096, EmulatorFaults{})
+	d, err := NewDongleEmulator(helmet)
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	defer d.Close()
+	d.BootTime = 300 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rec, err := NewTraceRecorder(NewSerialAdaptor(d.SlavePath), t.TempDir(), d.SlavePath, testMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	s := Transporter(rec)
+	if err := s.Connect(ctx, testMAC); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	if got := d.Resets(); got != 2 {
+		t.Errorf("resets = %d, want 2 (power-on and mode switch)", got)
+	}
+	if isLegacyDongle(d.SlavePath) {
+		t.Error("emulated dongle ACKs but was marked legacy")
+	}
+
+	fid, err := SendRequest(s, protocol.Unlock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitForACK(ctx, fid, 2*time.Second); err != nil {
+		t.Fatalf("unlock ACK: %v", err)
+	}
+	if !helmet.IsUnlocked() {
+		t.Error("helmet not unlocked through passthrough")
+	}
+	s.Disconnect()
+
+	events, err := LoadTrace(rec.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopScan := hex.EncodeToString(buildFrame(protocol.TargetDongle, 1, 0, protocol.StopScan{}.Encode()))
+	if len(events) == 0 || events[0].Dir != TraceTX || events[0].Data != stopScan {
+		t.Fatalf("trace does not start with the raw stop scan frame: %+v", events)
+	}
+
+	rt := NewReplayTransport(events)
+	if err := rt.Connect(ctx, testMAC); err != nil {
+		t.Fatalf("replay connect: %v", err)
+	}
+	fid, err = SendRequest(rt, protocol.Unlock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.WaitForACK(ctx, fid, time.Second); err != nil {
+		t.Errorf("replay unlock ACK: %v", err)
+	}
+	for _, d := range rt.Divergences {
+		t.Errorf("replay divergence: %s", d)
+	}
+}
+
+// 隔離中的自我測試：新版 Dongle 須回 ACK；已知的舊版 Dongle 不回 ACK 也要能通過
+func TestProbe(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		d, err := NewDongleEmulator()
+		if err != nil {
+			t.Skipf("pty unavailable: %v", err)
+		}
+		d.BootTime = 0
+		d.Legacy = legacy
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		s := NewSerialAdaptor(d.SlavePath)
+		err = s.Probe(ctx)
+		if legacy {
+			if err == nil {
+				t.Error("legacy dongle passed probe before it was known to be legacy")
+			}
+			legacyDongles.Store(d.SlavePath, time.Now())
+			err = s.Probe(ctx)
+			legacyDongles.Delete(d.SlavePath)
+		}
+		if err != nil {
+			t.Errorf("legacy=%v: probe: %v", legacy, err)
+		}
+		cancel()
+		d.Close()
+	}
+}
+
+// Dongle 以 NACK 拒絕 0x85 時不必等滿 6 秒，並回傳可判斷的錯誤
+func TestConnectRejected(t *testing.T) {
+	d, err := NewDongleEmulator(NewEmulatedHelmet(testMAC, 4096, EmulatorFaults{}))
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	defer d.Close()
+	d.BootTime = 0
+	d.RejectConnect = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	err = NewSerialAdaptor(d.SlavePath).Connect(ctx, testMAC)
+	if !errors.Is(err, ErrConnectRejected) {
+		t.Fatalf("connect: %v, want ErrConnectRejected", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("rejected connect took %s", elapsed)
+	}
+}
+
+// 舊版判定會過期；被判定為舊版的 Port 回了 ACK 就立即清除判定
+func TestLegacyDongleMarkExpires(t *testing.T) {
+	const port = "/dev/null-legacy-test"
+	legacyDongles.Store(port, time.Now().Add(-legacyDongleTTL-time.Second))
+	if isLegacyDongle(port) {
+		t.Error("expired legacy mark still honored")
+	}
+	if _, ok := legacyDongles.Load(port); ok {
+		t.Error("expired legacy mark not removed")
+	}
+
+	d, err := NewDongleEmulator(NewEmulatedHelmet(testMAC, 4096, EmulatorFaults{}))
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	defer d.Close()
+	d.BootTime = 0
+	legacyDongles.Store(d.SlavePath, time.Now())
+	defer legacyDongles.Delete(d.SlavePath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	s := NewSerialAdaptor(d.SlavePath)
+	if err := s.Connect(ctx, testMAC); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	s.Disconnect()
+	if isLegacyDongle(d.SlavePath) {
+		t.Error("dongle that ACKs stop scan is still marked legacy")
+	}
+}
+
+// 複製模式讀取來源 (背景 goroutine) 期間，IPC 指令仍會持有 MapMutex 讀取 Meta (以 -race 檢查)
+func TestCloneSourceMetaUnderMapMutex(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 1000))
+	d, err := NewDongleEmulator(flashedHelmet(meta, meta.RawData[adsChecksumOff:adsChecksumOff+2]))
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	defer d.Close()
+	d.BootTime = 0
+
+	dir := t.TempDir()
+	m := NewFactoryManager(Order{
+		CloneFrom: testMAC,
+		Ports:     []string{d.SlavePath},
+		Journal:   filepath.Join(dir, "progress.jsonl"),
+		History:   filepath.Join(dir, "history.jsonl"),
+	})
+	defer m.Stop()
+	m.addPort(PortInfo{Name: d.SlavePath})
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// 與 FailedDevices / progressKey 相同：持有 MapMutex 讀取 Meta
+			m.MapMutex.Lock()
+			_ = m.progressKey(testMAC)
+			m.MapMutex.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	ok := m.loadCloneSource()
+	close(stop)
+	<-done
+	if !ok {
+		t.Fatal("clone source not loaded")
+	}
+	if m.Meta.SHA256 != meta.SHA256 {
+		t.Errorf("clone hash %s, want %s", m.Meta.SHA256, meta.SHA256)
+	}
+}
+
+// 預設視窗大小經由未修改的 SerialAdaptor (pty) 完整燒錄並比對
+func TestSerialAdaptorDefaultWindowFlash(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 4096, 2, 3000))
+	helmet := NewEmulatedHelmet(testMAC, len(meta.EncodedData), EmulatorFaults{
+		DropACKRate:    0.02,
+		ResponseJitter: 2 * time.Millisecond,
+	})
+	d, err := NewDongleEmulator(helmet)
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	defer d.Close()
+	d.BootTime = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	s := NewSerialAdaptor(d.SlavePath)
+	offset := 0
+	if !PerformFlash(ctx, s, testMAC, meta, "[PTY]", &offset, DefaultWindowSize, nil) {
+		t.Fatalf("flash failed at offset %d", offset)
+	}
+	if !VerifyChecksumAndReboot(ctx, s, meta, "[PTY]") {
+		t.Fatal("checksum/reboot failed")
+	}
+	s.Disconnect()
+	if err := s.Connect(ctx, testMAC); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Disconnect()
+	if match, err := PerformFinalDebugCheck(ctx, s, meta, "[PTY]"); err != nil || !match {
+		t.Fatalf("verify: match=%v err=%v", match, err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// cmdEmulateDongle pty 模擬器僅支援 Linux
+func cmdEmulateDongle(ctx context.Context, args []string) int {
+	fmt.Fprintln(os.Stderr, "emulate-dongle: only supported on linux")
+	return 1
+}
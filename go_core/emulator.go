@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"BM2/protocol"
+)
+
+// ==========================================
+// 軟體模擬安全帽 (不需 Dongle / 實體設備)
+// ==========================================
+
+// EmulatorFaults 故障注入設定
+type EmulatorFaults struct {
+	DropACKRate           float64       // 丟棄 ACK 的機率 (0~1)
+	CorruptReadRate       float64       // 0xC7 回傳資料被竄改的機率 (0~1)
+	ResponseDelay         time.Duration // 每個回應的固定延遲
+	ResponseJitter        time.Duration // 額外的隨機延遲上限
+	DisconnectAfterWrites int           // 寫入 N 包後斷線一次 (0 = 不斷線)
+	ConnectFailures       int           // 前 N 次連線失敗
+}
+
+// EmulatedHelmet 保存一台安全帽的 Flash 映像與狀態
+type EmulatedHelmet struct {
+	MAC    string
+	Flash  []byte
+	Faults EmulatorFaults
+
+	Unlocked bool
+	Reboots  int
+	Writes   int
+
+	mu           sync.Mutex
+	rng          *rand.Rand
+	connectTries int
+	disconnected bool
+}
+
+func NewEmulatedHelmet(mac string, flashSize int, faults EmulatorFaults) *EmulatedHelmet {
+	return &EmulatedHelmet{
+		MAC:    mac,
+		Flash:  make([]byte, flashSize),
+		Faults: faults,
+		rng:    rand.New(rand.NewSource(1)),
+	}
+}
+
+// IsUnlocked 以鎖讀取解鎖狀態 (pty 模擬器在另一個 goroutine 處理指令)
+func (h *EmulatedHelmet) IsUnlocked() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.Unlocked
+}
+
+// Counts 以鎖讀取寫入包數與重啟次數
+func (h *EmulatedHelmet) Counts() (writes, reboots int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.Writes, h.Reboots
+}
+
+// accept 模擬連線；回傳錯誤代表設備不在範圍內
+func (h *EmulatedHelmet) accept() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connectTries++
+	if h.connectTries <= h.Faults.ConnectFailures {
+		return fmt.Errorf("emulator: connect refused (%d/%d)", h.connectTries, h.Faults.ConnectFailures)
+	}
+	return nil
+}
+
+// Handle 處理一個指令封包，回傳設備應答的封包 (可能為空)
+func (h *EmulatedHelmet) Handle(target byte, fid uint16, payload []byte) ([]Frame, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.disconnected {
+		return nil, fmt.Errorf("emulator: link lost")
+	}
+	if target != protocol.TargetHelmet || len(payload) == 0 {
+		// Dongle 層指令 (0x21 / 0x24) 不回應
+		return nil, nil
+	}
+
+	ack := Frame{Kind: FrameACK, Target: target, FID: fid, Payload: []byte{payload[0]}}
+	nack := Frame{Kind: FrameNACK, Target: target, FID: fid, Status: 1, Payload: []byte{payload[0]}}
+
+	var reply Frame
+	switch payload[0] {
+	case protocol.OpUnlock:
+		if _, err := protocol.DecodeUnlock(payload); err != nil {
+			return []Frame{nack}, nil
+		}
+		h.Unlocked = true
+		reply = ack
+
+	case protocol.OpReboot:
+		h.Unlocked = false
+		h.Reboots++
+		reply = ack
+
+	case protocol.OpWriteAudio:
+		w, err := protocol.DecodeWriteAudio(payload)
+		if err != nil || !h.Unlocked || int(w.Offset)+len(w.Data) > len(h.Flash) {
+			return []Frame{nack}, nil
+		}
+		copy(h.Flash[w.Offset:], w.Data)
+		h.Writes++
+		if h.Faults.DisconnectAfterWrites > 0 && h.Writes == h.Faults.DisconnectAfterWrites {
+			h.disconnected = true
+			return nil, nil
+		}
+		reply = ack
+
+	case protocol.OpRead:
+		r, err := protocol.DecodeRead(payload)
+		if err != nil || !h.Unlocked {
+			return []Frame{nack}, nil
+		}
+		end := int(r.Offset) + int(r.Size)
+		if end > len(h.Flash) {
+			end = len(h.Flash)
+		}
+		data := make([]byte, 0, r.Size)
+		if int(r.Offset) < end {
+			data = append(data, h.Flash[r.Offset:end]...)
+		}
+		if len(data) > 0 && h.chance(h.Faults.CorruptReadRate) {
+			data[h.rng.Intn(len(data))] ^= 0xFF
+		}
+		payload := protocol.ReadReply{Data: data}.Encode()
+		return []Frame{{Kind: FrameData, Target: target, FID: fid, Payload: payload}}, nil
+
+	default:
+		return []Frame{nack}, nil
+	}
+
+	if h.chance(h.Faults.DropACKRate) {
+		return nil, nil
+	}
+	return []Frame{reply}, nil
+}
+
+func (h *EmulatedHelmet) delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d := h.Faults.ResponseDelay
+	if h.Faults.ResponseJitter > 0 {
+		d += time.Duration(h.rng.Int63n(int64(h.Faults.ResponseJitter)))
+	}
+	return d
+}
+
+func (h *EmulatedHelmet) chance(rate float64) bool {
+	return rate > 0 && h.rng.Float64() < rate
+}
+
+// reconnect 斷線後重新連線即可恢復 (斷線故障只觸發一次)
+func (h *EmulatedHelmet) reconnect() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disconnected = false
+}
+
+// EmulatedTransport 以 Transporter 介面包裝 EmulatedHelmet
+type EmulatedTransport struct {
+	Helmet *EmulatedHelmet
+
+	connected   bool
+	internalFid uint16
+	pending     []pendingFrame
+}
+
+type pendingFrame struct {
+	readyAt time.Time
+	frame   Frame
+}
+
+func NewEmulatedTransport(h *EmulatedHelmet) *EmulatedTransport {
+	return &EmulatedTransport{Helmet: h}
+}
+
+func (e *EmulatedTransport) Connect(ctx context.Context, mac string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if mac != e.Helmet.MAC {
+		return fmt.Errorf("emulator: unknown mac %s", mac)
+	}
+	if err := e.Helmet.accept(); err != nil {
+		return err
+	}
+	e.Helmet.reconnect()
+	e.connected = true
+	e.internalFid = 0
+	e.pending = nil
+	return nil
+}
+
+func (e *EmulatedTransport) Disconnect() error {
+	e.connected = false
+	e.pending = nil
+	return nil
+}
+
+func (e *EmulatedTransport) SendCmd(target byte, payload []byte) (uint16, error) {
+	if !e.connected {
+		return 0, fmt.Errorf("port closed")
+	}
+	e.internalFid++
+	fid := e.internalFid
+	replies, err := e.Helmet.Handle(target, fid, payload)
+	if err != nil {
+		return fid, err
+	}
+	for _, f := range replies {
+		e.pending = append(e.pending, pendingFrame{readyAt: time.Now().Add(e.Helmet.delay()), frame: f})
+	}
+	return fid, nil
+}
+
+func (e *EmulatedTransport) SendAudioChunk(offset int, data []byte) (uint16, error) {
+	return SendRequest(e, protocol.WriteAudio{Offset: uint32(offset), Data: data})
+}
+
+func (e *EmulatedTransport) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	if !e.connected {
+		return Frame{}, fmt.Errorf("port closed")
+	}
+	deadline := time.Now().Add(timeout)
+	if len(e.pending) == 0 || e.pending[0].readyAt.After(deadline) {
+		if err := sleepCtx(ctx, timeout); err != nil {
+			return Frame{}, err
+		}
+		return Frame{}, ErrACKTimeout
+	}
+	next := e.pending[0]
+	if err := sleepCtx(ctx, time.Until(next.readyAt)); err != nil {
+		return Frame{}, err
+	}
+	e.pending = e.pending[1:]
+	return next.frame, nil
+}
+
+func (e *EmulatedTransport) ResetBuffer() {
+	e.pending = nil
+}
+
+func (e *EmulatedTransport) WaitForACK(ctx context.Context, fid uint16, timeout time.Duration) error {
+	return waitForACK(ctx, e, fid, timeout)
+}
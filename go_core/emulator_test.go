@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// 模擬器不需要等待連線穩定
+	verifySettleTime = 0
+	os.Exit(m.Run())
+}
+
+const testMAC = "EE:EE:EE:00:00:01"
+
+// burnAndVerify 與 selftest 相同的流程：燒錄 (失敗時接續 Offset 重試) → Checksum/重啟 → 重新連線比對
+func burnAndVerify(t *testing.T, meta FileMeta, faults EmulatorFaults, window int) (*EmulatedHelmet, bool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	helmet := NewEmulatedHelmet(testMAC, len(meta.EncodedData), faults)
+	tr := NewEmulatedTransport(helmet)
+	prefix := "[TEST]"
+
+	offset := 0
+	flashed := false
+	for attempt := 0; attempt < 3 && !flashed; attempt++ {
+		flashed = PerformFlash(ctx, tr, testMAC, meta, prefix, &offset, window, nil)
+	}
+	if !flashed {
+		t.Fatalf("flash failed at offset %d", offset)
+	}
+	if !VerifyChecksumAndReboot(ctx, tr, meta, prefix) {
+		t.Fatal("checksum/reboot failed")
+	}
+	tr.Disconnect()
+
+	if err := tr.Connect(ctx, testMAC); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	defer tr.Disconnect()
+	match, err := PerformFinalDebugCheck(ctx, tr, meta, prefix)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	return helmet, match
+}
+
+func TestEmulatorBurn(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 4096, 2, 3000, 3, 1536))
+	tests := []struct {
+		name   string
+		faults EmulatorFaults
+		window int
+	}{
+		{"clean", EmulatorFaults{}, 1},
+		{"clean windowed", EmulatorFaults{}, 4},
+		{"dropped ACKs", EmulatorFaults{DropACKRate: 0.05}, 1},
+		{"dropped ACKs windowed", EmulatorFaults{DropACKRate: 0.05}, 4},
+		{"response jitter", EmulatorFaults{ResponseJitter: 2 * time.Millisecond}, 4},
+		{"link lost mid-flash", EmulatorFaults{DisconnectAfterWrites: 10}, 1},
+		{"first connect refused", EmulatorFaults{ConnectFailures: 1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helmet, match := burnAndVerify(t, meta, tt.faults, tt.window)
+			if !match {
+				t.Fatal("verify mismatch")
+			}
+			if _, reboots := helmet.Counts(); reboots == 0 {
+				t.Error("helmet was never rebooted")
+			}
+		})
+	}
+}
+
+// 讀回資料被竄改時必須判定為不一致，而不是誤報成功
+func TestEmulatorDetectsCorruptRead(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 4096, 2, 3000))
+	if _, match := burnAndVerify(t, meta, EmulatorFaults{CorruptReadRate: 1}, 1); match {
+		t.Fatal("corrupted read-back reported as match")
+	}
+}
+
+// Trace 錄製不可繞過健康度統計 (RunWorker 的包裝順序)
+func TestHealthStatsThroughTrace(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 4096, 2, 3000))
+	helmet := NewEmulatedHelmet(testMAC, len(meta.EncodedData), EmulatorFaults{DropACKRate: 0.05})
+	rec, err := NewTraceRecorder(NewEmulatedTransport(helmet), t.TempDir(), "EMU", testMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	health := newHealthTransport(rec)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	offset := 0
+	if !PerformFlash(ctx, health, testMAC, meta, "[TEST]", &offset, 4, nil) {
+		t.Fatalf("flash failed at offset %d", offset)
+	}
+	if health.stats.BytesSent == 0 {
+		t.Error("BytesSent = 0")
+	}
+	if health.stats.ACKTimeouts == 0 {
+		t.Error("ACKTimeouts = 0 with dropped ACKs")
+	}
+}
+
+// linkDropTransport 在 after 次 ReadFrame 之後模擬 Port 被關閉 (USB 拔除)
+type linkDropTransport struct {
+	Transporter
+	after   int
+	reads   int
+	dropped time.Time
+}
+
+func (l *linkDropTransport) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	l.reads++
+	if l.reads > l.after {
+		if l.dropped.IsZero() {
+			l.dropped = time.Now()
+		}
+		return Frame{}, errors.New("port closed")
+	}
+	return l.Transporter.ReadFrame(ctx, timeout)
+}
+
+func (l *linkDropTransport) WaitForACK(ctx context.Context, fid uint16, timeout time.Duration) error {
+	return waitForACK(ctx, l, fid, timeout)
+}
+
+// 連線中斷時立即結束 (保留已確認的 Offset 供續燒)，不等 ACK 逾時或重送
+func TestFlashStopsOnLinkError(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 4096))
+	for _, window := range []int{1, DefaultWindowSize} {
+		helmet := NewEmulatedHelmet(testMAC, len(meta.EncodedData), EmulatorFaults{})
+		tr := &linkDropTransport{Transporter: NewEmulatedTransport(helmet), after: 10}
+		offset := 0
+		if PerformFlash(context.Background(), tr, testMAC, meta, "[TEST]", &offset, window, nil) {
+			t.Fatalf("window %d: flash succeeded after the link dropped", window)
+		}
+		if elapsed := time.Since(tr.dropped); elapsed > 150*time.Millisecond {
+			t.Errorf("window %d: kept retrying for %s after the link dropped", window, elapsed)
+		}
+		if offset == 0 || offset >= len(meta.EncodedData) {
+			t.Errorf("window %d: offset %d, want confirmed progress kept", window, offset)
+		}
+	}
+}
+
+// syntheticADS 產生測試用 ADS：參數依序為 (Track ID, PCM 大小) 配對
+func syntheticADS(idSizes ...int) []byte {
+	var tracks []ADSTrack
+	for i := 0; i+1 < len(idSizes); i += 2 {
+		id, size := idSizes[i], idSizes[i+1]
+		pcm := make([]byte, size)
+		for j := range pcm {
+			pcm[j] = byte((id*31 + j) & 0xff)
+		}
+		tracks = append(tracks, ADSTrack{ID: uint32(id), PCM: pcm})
+	}
+	image, err := BuildADS(tracks)
+	if err != nil {
+		panic(err) // 測試參數錯誤 (重複 ID 或超過 50 軌)
+	}
+	return image
+}
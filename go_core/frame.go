@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"BM2/protocol"
+)
+
+// ==========================================
+// Dongle 封包解碼器
+// 格式: 0x25 | target | fid(2) | status(2) | len(2) | payload | checksum
+// ==========================================
+
+const (
+	frameStart      = 0x25
+	frameHeaderSize = 8
+	maxFramePayload = 1024
+)
+
+// FrameKind 封包種類
+type FrameKind int
+
+const (
+	FrameACK  FrameKind = iota // 一般回應 (status == 0)
+	FrameNACK                  // 設備拒絕 (status != 0)
+	FrameData                  // 0xC7 讀取回傳資料
+)
+
+func (k FrameKind) String() string {
+	switch k {
+	case FrameACK:
+		return "ACK"
+	case FrameNACK:
+		return "NACK"
+	case FrameData:
+		return "DATA"
+	}
+	return fmt.Sprintf("FrameKind(%d)", int(k))
+}
+
+// Frame 定義一個已通過長度與 Checksum 檢查的封包
+type Frame struct {
+	Kind    FrameKind
+	Target  byte
+	FID     uint16
+	Status  uint16
+	Payload []byte
+}
+
+// buildFrame 組出與 SendCmd 相同格式的封包 (含 Checksum)
+func buildFrame(target byte, fid uint16, status uint16, payload []byte) []byte {
+	plLen := len(payload)
+	packet := make([]byte, 0, frameHeaderSize+plLen+1)
+	packet = append(packet, frameStart, target, byte(fid&0xff), byte((fid>>8)&0xff), byte(status&0xff), byte((status>>8)&0xff), byte(plLen&0xff), byte((plLen>>8)&0xff))
+	packet = append(packet, payload...)
+	return addChecksum(packet)
+}
+
+// classifyFrame 依 Payload 與 Status 判斷封包種類
+func classifyFrame(status uint16, payload []byte) FrameKind {
+	if len(payload) > 0 && payload[0] == protocol.OpReadReply {
+		return FrameData
+	}
+	if status != 0 {
+		return FrameNACK
+	}
+	return FrameACK
+}
+
+// FrameDecoder 將序列埠讀到的位元組流切成完整封包
+// 長度或 Checksum 不符時只丟棄 1 byte 重新同步，避免音訊資料中的 0x25 造成誤判
+type FrameDecoder struct {
+	buf []byte
+}
+
+// Feed 餵入新讀到的資料
+func (d *FrameDecoder) Feed(data []byte) {
+	d.buf = append(d.buf, data...)
+}
+
+// Reset 清空尚未解析的資料
+func (d *FrameDecoder) Reset() {
+	d.buf = d.buf[:0]
+}
+
+// Next 取出下一個完整封包；資料不足時回傳 false
+func (d *FrameDecoder) Next() (Frame, bool) {
+	for {
+		startIdx := bytes.IndexByte(d.buf, frameStart)
+		if startIdx == -1 {
+			d.buf = d.buf[:0]
+			return Frame{}, false
+		}
+		if startIdx > 0 {
+			d.buf = d.buf[startIdx:]
+		}
+		if len(d.buf) < frameHeaderSize {
+			return Frame{}, false
+		}
+
+		payloadLen := int(binary.LittleEndian.Uint16(d.buf[6:8]))
+		if payloadLen > maxFramePayload {
+			d.buf = d.buf[1:]
+			continue
+		}
+		packetLen := frameHeaderSize + payloadLen + 1
+		if len(d.buf) < packetLen {
+			return Frame{}, false
+		}
+
+		sum := 0
+		for i := 1; i < packetLen-1; i++ {
+			sum += int(d.buf[i])
+		}
+		if byte(sum&0xff) != d.buf[packetLen-1] {
+			d.buf = d.buf[1:]
+			continue
+		}
+
+		status := binary.LittleEndian.Uint16(d.buf[4:6])
+		payload := make([]byte, payloadLen)
+		copy(payload, d.buf[frameHeaderSize:frameHeaderSize+payloadLen])
+		f := Frame{
+			Kind:    classifyFrame(status, payload),
+			Target:  d.buf[1],
+			FID:     binary.LittleEndian.Uint16(d.buf[2:4]),
+			Status:  status,
+			Payload: payload,
+		}
+		d.buf = d.buf[packetLen:]
+		return f, true
+	}
+}
+
+// ErrACKTimeout 在期限內沒有收到回應 (ReadFrame / WaitForACK)；
+// 訊息維持 "timeout" 以相容既有的 Trace 檔
+var ErrACKTimeout = errors.New("timeout")
+
+// ErrNACK 設備以非 0 的 Status 拒絕指令
+var ErrNACK = errors.New("nack")
+
+// frameReader 可逐一讀取封包的來源 (SerialAdaptor、模擬器等)
+type frameReader interface {
+	ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error)
+}
+
+// waitForACK 等待指定 Frame ID 的回應；其他 ID 的遲到 ACK 直接丟棄，NACK 視為失敗
+func waitForACK(ctx context.Context, r frameReader, fid uint16, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrACKTimeout
+		}
+		f, err := r.ReadFrame(ctx, remaining)
+		if err != nil {
+			return err
+		}
+		if f.FID != fid {
+			continue
+		}
+		switch f.Kind {
+		case FrameACK:
+			return nil
+		case FrameNACK:
+			return fmt.Errorf("%w (status 0x%04X)", ErrNACK, f.Status)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"BM2/protocol"
+)
+
+func TestFrameDecoder(t *testing.T) {
+	ack := buildFrame(protocol.TargetHelmet, 7, 0, []byte{protocol.OpUnlock})
+	nack := buildFrame(protocol.TargetHelmet, 8, 1, []byte{protocol.OpWriteAudio})
+	data := buildFrame(protocol.TargetHelmet, 9, 0, []byte{protocol.OpReadReply, 0x25, 0x00})
+
+	badSum := append([]byte(nil), ack...)
+	badSum[len(badSum)-1]++
+	// 0x25 出現在雜訊中，且宣稱的長度超過上限
+	hugeLen := []byte{0x25, 0x01, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF}
+
+	join := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   []uint16 // 依序解出的 FID
+		kinds  []FrameKind
+	}{
+		{"single ACK", [][]byte{ack}, []uint16{7}, []FrameKind{FrameACK}},
+		{"NACK and DATA", [][]byte{join(nack, data)}, []uint16{8, 9}, []FrameKind{FrameNACK, FrameData}},
+		{"leading garbage", [][]byte{join([]byte{0x00, 0x13, 0x37}, ack)}, []uint16{7}, []FrameKind{FrameACK}},
+		{"garbage containing 0x25", [][]byte{join([]byte{0x25, 0x25, 0x01}, ack)}, []uint16{7}, []FrameKind{FrameACK}},
+		{"oversized length resyncs", [][]byte{join(hugeLen, ack)}, []uint16{7}, []FrameKind{FrameACK}},
+		{"checksum mismatch rejected", [][]byte{join(badSum, nack)}, []uint16{8}, []FrameKind{FrameNACK}},
+		{"only bad checksum", [][]byte{badSum}, nil, nil},
+		{"split across reads", [][]byte{ack[:3], ack[3:10], ack[10:]}, []uint16{7}, []FrameKind{FrameACK}},
+		{"partial header waits", [][]byte{ack[:5]}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d FrameDecoder
+			var fids []uint16
+			var kinds []FrameKind
+			for _, c := range tt.chunks {
+				d.Feed(c)
+				for {
+					f, ok := d.Next()
+					if !ok {
+						break
+					}
+					fids = append(fids, f.FID)
+					kinds = append(kinds, f.Kind)
+				}
+			}
+			if !reflect.DeepEqual(fids, tt.want) || !reflect.DeepEqual(kinds, tt.kinds) {
+				t.Errorf("got FIDs %v kinds %v, want %v %v", fids, kinds, tt.want, tt.kinds)
+			}
+		})
+	}
+}
+
+// scriptedReader 依序回傳預先排好的封包，用完後逾時
+type scriptedReader struct {
+	frames []Frame
+}
+
+func (r *scriptedReader) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	if len(r.frames) == 0 {
+		return Frame{}, ErrACKTimeout
+	}
+	f := r.frames[0]
+	r.frames = r.frames[1:]
+	return f, nil
+}
+
+func TestWaitForACK(t *testing.T) {
+	ack := func(fid uint16) Frame { return Frame{Kind: FrameACK, FID: fid} }
+	nack := func(fid uint16) Frame { return Frame{Kind: FrameNACK, FID: fid, Status: 1} }
+
+	tests := []struct {
+		name    string
+		frames  []Frame
+		wantErr string // 空字串代表成功
+	}{
+		{"matching ACK", []Frame{ack(5)}, ""},
+		{"skips stale ACKs", []Frame{ack(3), ack(4), ack(5)}, ""},
+		{"skips other NACK", []Frame{nack(4), ack(5)}, ""},
+		{"skips DATA with same FID", []Frame{{Kind: FrameData, FID: 5}, ack(5)}, ""},
+		{"matching NACK fails", []Frame{ack(4), nack(5)}, "nack (status 0x0001)"},
+		{"only stale ACKs time out", []Frame{ack(3), ack(4)}, ErrACKTimeout.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := waitForACK(context.Background(), &scriptedReader{frames: tt.frames}, 5, time.Second)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("err = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ go 1.24.2
 
 require (
 	go.bug.st/serial v1.6.4
+	golang.org/x/sys v0.19.0
 	tinygo.org/x/bluetooth v0.14.0
 )
 
@@ -18,5 +19,4 @@ require (
 	github.com/tinygo-org/cbgo v0.0.4 // indirect
 	github.com/tinygo-org/pio v0.2.0 // indirect
 	golang.org/x/exp v0.0.0-20241204233417-43b7b7cde48d // indirect
-	golang.org/x/sys v0.19.0 // indirect
 )
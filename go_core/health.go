@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ==========================================
+// Dongle 健康度統計與自動隔離
+// 以最近幾次作業的失敗率判斷 (只計 Dongle 端造成的失敗，安全帽的問題不算)；被隔離的 Port 不再派工，
+// 定期自我測試 (Port 可開啟並送出 Stop Scan) 連續通過後才放回 IdlePorts
+// ==========================================
+
+const (
+	healthWindow        = 6   // 以最近幾次作業計算失敗率
+	healthMinJobs       = 3   // 作業數不足時不判定
+	quarantineThreshold = 0.5 // 失敗率達到此值即隔離
+	probeInterval       = 30 * time.Second
+	probePasses         = 2 // 連續通過幾次自我測試才解除隔離
+)
+
+// PortHealth 單一 Port 的累計統計 (HEALTH 事件的 data)
+type PortHealth struct {
+	Port            string  `json:"port"`
+	Jobs            int     `json:"jobs"`
+	Failures        int     `json:"failures"` // Dongle 端造成的 RELEASE
+	ConnectFailures int     `json:"connect_failures"`
+	ACKTimeouts     int     `json:"ack_timeouts"`
+	Retransmits     int     `json:"retransmits"`
+	BytesSent       int64   `json:"bytes_sent"`
+	BytesPerSec     float64 `json:"bytes_per_sec"`
+	FailureRate     float64 `json:"failure_rate"`
+	Quarantined     bool    `json:"quarantined"`
+
+	recent   []bool // 最近作業結果，true 代表失敗
+	sendTime time.Duration
+}
+
+// jobStats 單次作業期間由 healthTransport 收集的數據
+type jobStats struct {
+	ConnectAttempts int
+	ConnectFailures int
+	ACKTimeouts     int
+	Retransmits     int
+	BytesSent       int64
+	SendTime        time.Duration
+	DongleFault     bool // 曾發生 ErrDongleFault (Port 開啟或讀寫失敗)
+
+	sent        map[int]bool
+	first, last time.Time
+}
+
+// healthTransport 包裝 Transporter 以統計連線失敗、ACK 逾時、重送與傳輸量
+type healthTransport struct {
+	Transporter
+	stats jobStats
+}
+
+func newHealthTransport(inner Transporter) *healthTransport {
+	return &healthTransport{Transporter: inner, stats: jobStats{sent: make(map[int]bool)}}
+}
+
+func (h *healthTransport) Connect(ctx context.Context, mac string) error {
+	h.stats.ConnectAttempts++
+	err := h.Transporter.Connect(ctx, mac)
+	if err != nil && ctx.Err() == nil {
+		h.stats.ConnectFailures++
+	}
+	return h.note(err)
+}
+
+// note 記錄 Dongle 端的故障，錯誤原樣回傳
+func (h *healthTransport) note(err error) error {
+	if errors.Is(err, ErrDongleFault) {
+		h.stats.DongleFault = true
+	}
+	return err
+}
+
+func (h *healthTransport) SendCmd(target byte, payload []byte) (uint16, error) {
+	fid, err := h.Transporter.SendCmd(target, payload)
+	return fid, h.note(err)
+}
+
+func (h *healthTransport) SendAudioChunk(offset int, data []byte) (uint16, error) {
+	// Checksum 欄位 (604~605) 的初始化與回寫不算傳輸量
+	if offset >= 604 && offset+len(data) <= 606 {
+		return h.Transporter.SendAudioChunk(offset, data)
+	}
+	now := time.Now()
+	if h.stats.first.IsZero() {
+		h.stats.first = now
+	}
+	h.stats.last = now
+	if h.stats.sent[offset] {
+		h.stats.Retransmits++
+	}
+	h.stats.sent[offset] = true
+	h.stats.BytesSent += int64(len(data))
+	h.stats.SendTime = h.stats.last.Sub(h.stats.first)
+	fid, err := h.Transporter.SendAudioChunk(offset, data)
+	return fid, h.note(err)
+}
+
+func (h *healthTransport) WaitForACK(ctx context.Context, fid uint16, timeout time.Duration) error {
+	err := h.Transporter.WaitForACK(ctx, fid, timeout)
+	if errors.Is(err, ErrACKTimeout) {
+		h.stats.ACKTimeouts++
+	}
+	return h.note(err)
+}
+
+// ReadFrame 視窗模式直接以 ReadFrame 等待 ACK，逾時同樣計入
+func (h *healthTransport) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	f, err := h.Transporter.ReadFrame(ctx, timeout)
+	if errors.Is(err, ErrACKTimeout) {
+		h.stats.ACKTimeouts++
+	}
+	return f, h.note(err)
+}
+
+// snapshot 複製一份並算出衍生欄位 (呼叫端須持有 PortMutex)
+func (p *PortHealth) snapshot() PortHealth {
+	s := *p
+	s.recent = nil
+	if p.sendTime > 0 {
+		s.BytesPerSec = float64(p.BytesSent) / p.sendTime.Seconds()
+	}
+	s.FailureRate = p.failureRate()
+	return s
+}
+
+func (p *PortHealth) failureRate() float64 {
+	if len(p.recent) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, f := range p.recent {
+		if f {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(p.recent))
+}
+
+// health 取得 Port 的統計，不存在時建立 (呼叫端須持有 PortMutex)
+func (m *FactoryManager) health(port string) *PortHealth {
+	h := m.Health[port]
+	if h == nil {
+		h = &PortHealth{Port: port}
+		m.Health[port] = h
+	}
+	return h
+}
+
+// isQuarantined 呼叫端須持有 PortMutex
+func (m *FactoryManager) isQuarantined(port string) bool {
+	h := m.Health[port]
+	return h != nil && h.Quarantined
+}
+
+// recordHealth 作業結束時累計統計；failed 只應包含 Dongle 端造成的失敗，失敗率超過門檻即隔離
+// (須在 releasePort 之前呼叫)
+func (m *FactoryManager) recordHealth(port string, stats jobStats, failed bool) {
+	m.PortMutex.Lock()
+	h := m.health(port)
+	h.Jobs++
+	if failed {
+		h.Failures++
+	}
+	h.ConnectFailures += stats.ConnectFailures
+	h.ACKTimeouts += stats.ACKTimeouts
+	h.Retransmits += stats.Retransmits
+	h.BytesSent += stats.BytesSent
+	h.sendTime += stats.SendTime
+	h.recent = append(h.recent, failed)
+	if len(h.recent) > healthWindow {
+		h.recent = h.recent[len(h.recent)-healthWindow:]
+	}
+	quarantine := !h.Quarantined && len(h.recent) >= healthMinJobs && h.failureRate() >= quarantineThreshold
+	if quarantine {
+		h.Quarantined = true
+	}
+	snap := h.snapshot()
+	m.PortMutex.Unlock()
+
+	if !quarantine {
+		sendEvent("HEALTH", port, "OK", snap)
+		return
+	}
+	sendEvent("HEALTH", port, "QUARANTINED", snap)
+	sendLog(port, fmt.Sprintf("🚧 Dongle 失敗率 %.0f%%，暫停派工並定期自我測試", snap.FailureRate*100))
+	m.spawn(func() { m.runQuarantineProbe(port) })
+}
+
+// runQuarantineProbe 定期自我測試被隔離的 Port，連續通過後解除隔離並放回 IdlePorts
+func (m *FactoryManager) runQuarantineProbe(port string) {
+	passes := 0
+	for passes < probePasses {
+		if sleepCtx(m.ctx, probeInterval) != nil {
+			return
+		}
+		m.PortMutex.Lock()
+		active := m.ActivePorts[port]
+		m.PortMutex.Unlock()
+		if !active {
+			// 已拔除：等它插回來再測
+			passes = 0
+			continue
+		}
+
+		if err := probePort(m.ctx, port); err != nil {
+			passes = 0
+			sendLog(port, fmt.Sprintf("🚧 自我測試失敗: %v", err))
+			continue
+		}
+		passes++
+	}
+
+	m.PortMutex.Lock()
+	h := m.health(port)
+	h.Quarantined = false
+	h.recent = nil
+	push := m.ActivePorts[port] && !m.PooledPorts[port]
+	if push {
+		m.PooledPorts[port] = true
+	}
+	snap := h.snapshot()
+	m.PortMutex.Unlock()
+
+	if push {
+		m.offerPort(port)
+	}
+	sendEvent("HEALTH", port, "RESTORED", snap)
+	sendLog(port, "✅ Dongle 自我測試通過，恢復派工")
+}
+
+// probePort 對 Dongle 做不需要安全帽的自我測試；主機藍牙沒有 Dongle，視為通過
+func probePort(ctx context.Context, port string) error {
+	if isBLEPort(port) {
+		return nil
+	}
+	return NewSerialAdaptor(port).Probe(ctx)
+}
+
+// HealthReport 回傳所有 Port 的統計 (依名稱排序)
+func (m *FactoryManager) HealthReport() []PortHealth {
+	m.PortMutex.Lock()
+	defer m.PortMutex.Unlock()
+	report := make([]PortHealth, 0, len(m.Health))
+	for _, h := range m.Health {
+		report = append(report, h.snapshot())
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Port < report[j].Port })
+	return report
+}
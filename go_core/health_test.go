@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// brokenPortTransport 模擬 Dongle 寫入失敗 (USB 拔除等)
+type brokenPortTransport struct {
+	Transporter
+}
+
+func (b *brokenPortTransport) SendCmd(target byte, payload []byte) (uint16, error) {
+	return 0, errors.Join(ErrDongleFault, io.ErrClosedPipe)
+}
+
+func (b *brokenPortTransport) SendAudioChunk(offset int, data []byte) (uint16, error) {
+	return b.SendCmd(0, data)
+}
+
+// 只有 Dongle 端的故障 (Port 開啟、讀寫失敗) 會標記 DongleFault；安全帽拒絕連線或不回應不算
+func TestHealthClassifiesFailures(t *testing.T) {
+	ctx := context.Background()
+
+	refusing := NewEmulatedHelmet(testMAC, 4096, EmulatorFaults{ConnectFailures: 1})
+	h := newHealthTransport(NewEmulatedTransport(refusing))
+	if err := h.Connect(ctx, testMAC); err == nil {
+		t.Fatal("helmet refused the connect but Connect succeeded")
+	}
+	if h.stats.DongleFault {
+		t.Error("helmet-side connect failure counted as a dongle fault")
+	}
+	if err := h.Connect(ctx, testMAC); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.WaitForACK(ctx, 99, 10*time.Millisecond); !errors.Is(err, ErrACKTimeout) {
+		t.Fatalf("wait: %v", err)
+	}
+	if h.stats.DongleFault {
+		t.Error("ACK timeout after connecting counted as a dongle fault")
+	}
+
+	h = newHealthTransport(&brokenPortTransport{Transporter: NewEmulatedTransport(refusing)})
+	if _, err := h.SendAudioChunk(1024, make([]byte, 16)); err == nil {
+		t.Fatal("write to broken port succeeded")
+	}
+	if !h.stats.DongleFault {
+		t.Error("write failure not counted as a dongle fault")
+	}
+
+	h = newHealthTransport(NewSerialAdaptor(filepath.Join(t.TempDir(), "missing-port")))
+	if err := h.Connect(ctx, testMAC); !errors.Is(err, ErrDongleFault) {
+		t.Errorf("open failure: %v, want ErrDongleFault", err)
+	}
+	if !h.stats.DongleFault {
+		t.Error("open failure not counted as a dongle fault")
+	}
+}
+
+// 同一頂壞安全帽在健康的 Dongle 上重試不會讓 Dongle 被隔離
+func TestHealthIgnoresHelmetFailures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &FactoryManager{
+		ActivePorts: make(map[string]bool),
+		PooledPorts: make(map[string]bool),
+		Health:      make(map[string]*PortHealth),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	defer func() {
+		cancel()
+		m.routines.Wait()
+	}()
+
+	const port = "COM9"
+	helmetSide := jobStats{ConnectAttempts: 1, ConnectFailures: 1}
+	for i := 0; i < healthWindow; i++ {
+		m.recordHealth(port, helmetSide, helmetSide.DongleFault)
+	}
+	if m.isQuarantined(port) {
+		t.Fatal("dongle quarantined for helmet-side failures")
+	}
+
+	dongleSide := jobStats{DongleFault: true}
+	for i := 0; i < healthWindow; i++ {
+		m.recordHealth(port, dongleSide, dongleSide.DongleFault)
+	}
+	m.PortMutex.Lock()
+	defer m.PortMutex.Unlock()
+	if !m.isQuarantined(port) {
+		t.Error("dongle with repeated port failures was not quarantined")
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 燒錄履歷 (每個 RunWorker 結果一筆，JSONL 附加寫入)
+// 回答「這頂安全帽是哪個映像、何時燒的」；可依 MAC / DasID / 日期查詢與匯出
+// ==========================================
+
+// PhaseTiming 單一階段的起訖時間
+type PhaseTiming struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	OK    bool      `json:"ok"`
+}
+
+// BurnRecord 一次作業的完整紀錄
+type BurnRecord struct {
+	MAC       string `json:"mac"`
+	Name      string `json:"name"`
+	DasID     string `json:"das_id,omitempty"`
+	Port      string `json:"port"`
+	ImageHash string `json:"image_hash"`
+	ImageFile string `json:"image_file,omitempty"`
+
+	Outcome      string        `json:"outcome"`                // SUCCESS, REBURN, RELEASE, CANCELLED, FAILED
+	FailReason   string        `json:"fail_reason,omitempty"`  // 重試額度用盡的原因
+	Verification string        `json:"verification,omitempty"` // MATCH, MISMATCH, READ_ERROR
+	Started      time.Time     `json:"started"`
+	Finished     time.Time     `json:"finished"`
+	Phases       []PhaseTiming `json:"phases,omitempty"`
+
+	ResumedFrom     int `json:"resumed_from"`
+	FinalOffset     int `json:"final_offset"`
+	ConnectAttempts int `json:"connect_attempts"`
+	ACKTimeouts     int `json:"ack_timeouts"`
+	Retransmits     int `json:"retransmits"`
+
+	Tracks []TrackInfo `json:"tracks,omitempty"`
+}
+
+// phase 開始一個階段，回傳結束時呼叫的函式
+func (r *BurnRecord) phase(name string) func(ok bool) {
+	r.Phases = append(r.Phases, PhaseTiming{Name: name, Start: time.Now()})
+	i := len(r.Phases) - 1
+	return func(ok bool) {
+		r.Phases[i].End = time.Now()
+		r.Phases[i].OK = ok
+	}
+}
+
+// sortedTracks 依表格序號排列的音軌
+func sortedTracks(tracks map[int]TrackInfo) []TrackInfo {
+	keys := make([]int, 0, len(tracks))
+	for k := range tracks {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	list := make([]TrackInfo, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, tracks[k])
+	}
+	return list
+}
+
+// HistoryStore 每筆紀錄各自開檔附加並 fsync，工作停止後仍在收尾的作業也能寫入
+type HistoryStore struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// defaultHistoryPath 使用者設定目錄下的 BeeMaster/history.jsonl
+func defaultHistoryPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "BeeMaster", "history.jsonl"), nil
+}
+
+// openHistory 未指定路徑時使用預設位置；無法取得設定目錄時回傳 nil (不記錄)
+func openHistory(path string) *HistoryStore {
+	if path == "" {
+		path, _ = defaultHistoryPath()
+	}
+	if path == "" {
+		return nil
+	}
+	return &HistoryStore{Path: path}
+}
+
+func (h *HistoryStore) Append(rec BurnRecord) error {
+	if h == nil || h.Path == "" {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// HistoryFilter 空欄位代表不限；To 為不含的上限
+type HistoryFilter struct {
+	MAC   string
+	DasID string
+	From  time.Time
+	To    time.Time
+}
+
+func (f HistoryFilter) match(r BurnRecord) bool {
+	if f.MAC != "" && !strings.EqualFold(f.MAC, r.MAC) {
+		return false
+	}
+	if f.DasID != "" && f.DasID != r.DasID && !strings.Contains(r.Name, f.DasID) {
+		return false
+	}
+	if !f.From.IsZero() && r.Finished.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !r.Finished.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// parseHistoryRange 接受 2006-01-02 或 RFC3339；只有日期的 to 包含當天整天
+func parseHistoryRange(from, to string) (time.Time, time.Time, error) {
+	parse := func(s string, endOfDay bool) (time.Time, error) {
+		if s == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+			if endOfDay {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+		return time.Parse(time.RFC3339, s)
+	}
+	f, err := parse(from, false)
+	if err != nil {
+		return f, f, fmt.Errorf("from: %v", err)
+	}
+	t, err := parse(to, true)
+	if err != nil {
+		return f, t, fmt.Errorf("to: %v", err)
+	}
+	return f, t, nil
+}
+
+// Query 依時間排序回傳符合條件的紀錄；檔案不存在時回傳空清單
+func (h *HistoryStore) Query(filter HistoryFilter) ([]BurnRecord, error) {
+	records := []BurnRecord{}
+	if h == nil {
+		return records, nil
+	}
+	f, err := os.Open(h.Path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var rec BurnRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			continue
+		}
+		if filter.match(rec) {
+			records = append(records, rec)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Finished.Before(records[j].Finished) })
+	return records, scanner.Err()
+}
+
+var historyCSVHeader = []string{
+	"finished", "started", "mac", "das_id", "name", "port", "outcome", "fail_reason", "verification",
+	"image_file", "image_hash", "resumed_from", "final_offset", "connect_attempts",
+	"ack_timeouts", "retransmits", "tracks", "phases",
+}
+
+// WriteHistoryCSV 匯出 CSV；階段以 NAME=秒數 串成一欄
+func WriteHistoryCSV(w io.Writer, records []BurnRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write(historyCSVHeader)
+	for _, r := range records {
+		var phases []string
+		for _, p := range r.Phases {
+			phases = append(phases, fmt.Sprintf("%s=%.1fs", p.Name, p.End.Sub(p.Start).Seconds()))
+		}
+		cw.Write([]string{
+			r.Finished.Format(time.RFC3339), r.Started.Format(time.RFC3339), r.MAC, r.DasID, r.Name, r.Port,
+			r.Outcome, r.FailReason, r.Verification, r.ImageFile, r.ImageHash,
+			strconv.Itoa(r.ResumedFrom), strconv.Itoa(r.FinalOffset), strconv.Itoa(r.ConnectAttempts),
+			strconv.Itoa(r.ACKTimeouts), strconv.Itoa(r.Retransmits), strconv.Itoa(len(r.Tracks)),
+			strings.Join(phases, ";"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportHistory 依格式 (json/csv) 寫出
+func ExportHistory(w io.Writer, records []BurnRecord, format string) error {
+	switch format {
+	case "csv":
+		return WriteHistoryCSV(w, records)
+	case "json", "":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// queryHistory 依 IPC 指令的條件查詢；工作中使用同一份履歷
+func queryHistory(order Order) ([]BurnRecord, error) {
+	from, to, err := parseHistoryRange(order.From, order.To)
+	if err != nil {
+		return nil, err
+	}
+	store := openHistory(order.History)
+	if manager != nil && order.History == "" {
+		store = manager.History
+	}
+	return store.Query(HistoryFilter{MAC: order.MAC, DasID: order.DasID, From: from, To: to})
+}
+
+const (
+	historyPageSize = 100
+	historyPageMax  = 1000 // 避免單行 JSON 過大
+)
+
+// pageHistory 由最新往回跳過 skip 筆，取最多 limit 筆；回傳結果仍依時間排序
+func pageHistory(records []BurnRecord, skip, limit int) []BurnRecord {
+	if limit <= 0 {
+		limit = historyPageSize
+	}
+	if limit > historyPageMax {
+		limit = historyPageMax
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	end := len(records) - skip
+	if end <= 0 {
+		return []BurnRecord{}
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	return records[start:end]
+}
+
+// exportHistoryFile 寫到暫存檔後改名，避免匯出中斷留下半個檔案
+func exportHistoryFile(path string, records []BurnRecord, format string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := ExportHistory(f, records, format); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageHistory(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := make([]BurnRecord, 250)
+	for i := range records {
+		records[i].Finished = base.Add(time.Duration(i) * time.Minute)
+		records[i].FinalOffset = i
+	}
+
+	tests := []struct {
+		name        string
+		skip, limit int
+		first, n    int
+	}{
+		{"default page is the newest 100", 0, 0, 150, 100},
+		{"second page", 100, 0, 50, 100},
+		{"partial last page", 200, 0, 0, 50},
+		{"past the end", 300, 0, 0, 0},
+		{"explicit limit", 10, 5, 235, 5},
+		{"limit capped", 0, 5000, 0, 250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := pageHistory(records, tt.skip, tt.limit)
+			if len(page) != tt.n {
+				t.Fatalf("len = %d, want %d", len(page), tt.n)
+			}
+			if tt.n > 0 && page[0].FinalOffset != tt.first {
+				t.Errorf("first = %d, want %d", page[0].FinalOffset, tt.first)
+			}
+		})
+	}
+}
+
+func TestHistoryQueryFilters(t *testing.T) {
+	store := &HistoryStore{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+	day := func(d, h, m int) time.Time { return time.Date(2026, 3, d, h, m, 0, 0, time.Local) }
+	records := []BurnRecord{
+		{MAC: "AA:00:00:00:00:01", DasID: "DAS1", Name: "BEE-DAS1", Finished: day(1, 0, 0)},
+		{MAC: "AA:00:00:00:00:02", Name: "BEE-DAS2", Finished: day(2, 23, 59)}, // DasID 只在名稱中
+		{MAC: "AA:00:00:00:00:01", DasID: "DAS1", Name: "BEE-DAS1", Finished: day(3, 0, 0)},
+		{MAC: "AA:00:00:00:00:03", DasID: "DAS3", Name: "BEE-DAS3", Finished: day(1, 0, 0).Add(-time.Minute)},
+	}
+	for _, r := range records {
+		if err := store.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 損壞的一行 (例如寫到一半斷電) 略過
+	f, err := os.OpenFile(store.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"mac\": \"AA:00\n")
+	f.Close()
+
+	from, to, err := parseHistoryRange("2026-03-01", "2026-03-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   []time.Time
+	}{
+		{"all", HistoryFilter{}, []time.Time{records[3].Finished, records[0].Finished, records[1].Finished, records[2].Finished}},
+		{"mac ignores case", HistoryFilter{MAC: "aa:00:00:00:00:01"}, []time.Time{records[0].Finished, records[2].Finished}},
+		{"das id field or name", HistoryFilter{DasID: "DAS2"}, []time.Time{records[1].Finished}},
+		{"date range includes the whole end day", HistoryFilter{From: from, To: to}, []time.Time{records[0].Finished, records[1].Finished}},
+		{"combined", HistoryFilter{MAC: "AA:00:00:00:00:01", From: from, To: to}, []time.Time{records[0].Finished}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var finished []time.Time
+			for _, r := range got {
+				finished = append(finished, r.Finished)
+			}
+			if len(finished) != len(tt.want) {
+				t.Fatalf("got %v, want %v", finished, tt.want)
+			}
+			for i := range finished {
+				if !finished[i].Equal(tt.want[i]) {
+					t.Errorf("record %d finished %v, want %v", i, finished[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, _, err := parseHistoryRange("03/01/2026", ""); err == nil {
+		t.Error("unsupported date format accepted")
+	}
+	rfcFrom, _, err := parseHistoryRange("2026-03-01T12:00:00Z", "")
+	if err != nil || !rfcFrom.Equal(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("RFC3339 from = %v, %v", rfcFrom, err)
+	}
+}
+
+func TestWriteHistoryCSV(t *testing.T) {
+	start := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	rec := BurnRecord{
+		MAC:         "AA:00:00:00:00:01",
+		Name:        "BEE \"A\", line 1\nline 2",
+		Outcome:     "SUCCESS",
+		Started:     start,
+		Finished:    start.Add(90 * time.Second),
+		FinalOffset: 4096,
+		Phases: []PhaseTiming{
+			{Name: "CONNECT", Start: start, End: start.Add(1500 * time.Millisecond)},
+			{Name: "FLASH", Start: start, End: start.Add(60 * time.Second)},
+		},
+		Tracks: []TrackInfo{{ID: 1}, {ID: 2}},
+	}
+	var buf bytes.Buffer
+	if err := WriteHistoryCSV(&buf, []BurnRecord{rec}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"BEE ""A"", line 1`) {
+		t.Errorf("name not quoted and escaped:\n%s", buf.String())
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("rows = %d, want header and one record", len(rows))
+	}
+	if !reflect.DeepEqual(rows[0], historyCSVHeader) {
+		t.Errorf("header %v", rows[0])
+	}
+	row := make(map[string]string)
+	for i, col := range historyCSVHeader {
+		row[col] = rows[1][i]
+	}
+	want := map[string]string{
+		"finished":     "2026-03-01T08:01:30Z",
+		"name":         rec.Name,
+		"final_offset": "4096",
+		"tracks":       "2",
+		"phases":       "CONNECT=1.5s;FLASH=60.0s",
+	}
+	for col, v := range want {
+		if row[col] != v {
+			t.Errorf("%s = %q, want %q", col, row[col], v)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 燒錄進度日誌 (Write-Ahead Log)
+// 每筆變更先寫入並 fsync 才生效；以 (MAC, ADS 內容雜湊) 為 key，
+// 換了 ADS 檔案的進度不會被誤用。開啟時重播並壓縮成每個 key 一行
+// ==========================================
+
+const (
+	journalOffset = "OFFSET"
+	journalDone   = "DONE"
+	journalFail   = "FAIL"
+	journalClear  = "CLEAR"
+	journalReburn = "REBURN"
+	journalTime   = "ELAPSED" // 累加作業時間
+	journalFailed = "FAILED"  // 重試額度用盡，終止
+	journalReset  = "RESET"   // 操作員重置額度與 FAILED 狀態
+	journalState  = "STATE"   // 壓縮後的完整狀態
+)
+
+// JournalEntry 單一設備在某個 ADS 下的進度
+type JournalEntry struct {
+	MAC      string    `json:"mac"`
+	Hash     string    `json:"hash"`
+	Offset   int       `json:"offset"`
+	Done     bool      `json:"done"`
+	Failures int       `json:"failures"`
+	Reburns  int       `json:"reburns"`
+	Elapsed  int64     `json:"elapsed_ms"`
+	Failed   string    `json:"failed,omitempty"` // FAILED 的原因
+	Updated  time.Time `json:"updated"`
+}
+
+// hasBudget 是否有需要跨工作保留的重試紀錄
+func (e *JournalEntry) hasBudget() bool {
+	return e.Failures > 0 || e.Reburns > 0 || e.Elapsed > 0 || e.Failed != ""
+}
+
+// budget 轉為 DeviceBudget (日誌的 Failures 即 RELEASE 次數)
+func (e *JournalEntry) budget() *DeviceBudget {
+	return &DeviceBudget{
+		MAC:      e.MAC,
+		Hash:     e.Hash,
+		Reburns:  e.Reburns,
+		Releases: e.Failures,
+		Elapsed:  time.Duration(e.Elapsed) * time.Millisecond,
+		Failed:   e.Failed,
+	}
+}
+
+type journalRecord struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	MAC      string    `json:"mac"`
+	Hash     string    `json:"hash"`
+	Offset   int       `json:"offset,omitempty"`
+	Done     bool      `json:"done,omitempty"`
+	Failures int       `json:"failures,omitempty"`
+	Reburns  int       `json:"reburns,omitempty"`
+	Elapsed  int64     `json:"elapsed_ms,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+type journalKey struct {
+	MAC, Hash string
+}
+
+// Journal 所有方法對 nil 皆安全 (未啟用日誌時直接略過)
+type Journal struct {
+	Path string
+
+	mu      sync.Mutex
+	f       *os.File
+	entries map[journalKey]*JournalEntry
+}
+
+// defaultJournalPath 使用者設定目錄下的 BeeMaster/progress.jsonl
+func defaultJournalPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "BeeMaster", "progress.jsonl"), nil
+}
+
+// OpenJournal 載入既有日誌 (忽略當機時寫壞的最後一行)，壓縮後以附加模式開啟
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	j := &Journal{Path: path, entries: make(map[journalKey]*JournalEntry)}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec journalRecord
+			if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+				continue
+			}
+			j.apply(rec)
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j.f = f
+	return j, nil
+}
+
+// apply 將一筆紀錄套用到記憶體狀態 (呼叫端須持有 mu 或尚未公開)
+func (j *Journal) apply(rec journalRecord) {
+	key := journalKey{rec.MAC, rec.Hash}
+	e := j.entries[key]
+	if rec.Event == journalClear {
+		// 重燒只清進度，重試紀錄保留
+		if e != nil && e.hasBudget() {
+			e.Offset, e.Done, e.Updated = 0, false, rec.Time
+		} else {
+			delete(j.entries, key)
+		}
+		return
+	}
+	if e == nil {
+		e = &JournalEntry{MAC: rec.MAC, Hash: rec.Hash}
+		j.entries[key] = e
+	}
+	e.Updated = rec.Time
+	switch rec.Event {
+	case journalOffset:
+		e.Offset = rec.Offset
+		e.Done = false
+	case journalDone:
+		e.Done = true
+	case journalFail:
+		e.Failures++
+	case journalReburn:
+		e.Reburns++
+	case journalTime:
+		e.Elapsed += rec.Elapsed
+	case journalFailed:
+		e.Failed = rec.Reason
+	case journalReset:
+		e.Failures, e.Reburns, e.Elapsed, e.Failed = 0, 0, 0, ""
+		if e.Offset == 0 && !e.Done {
+			delete(j.entries, key)
+		}
+	case journalState:
+		e.Offset, e.Done, e.Failures = rec.Offset, rec.Done, rec.Failures
+		e.Reburns, e.Elapsed, e.Failed = rec.Reburns, rec.Elapsed, rec.Reason
+	}
+}
+
+// compact 以暫存檔寫出目前狀態後改名取代，避免中途當機留下半個檔案
+func (j *Journal) compact() error {
+	tmp := j.Path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range j.sorted("") {
+		enc.Encode(journalRecord{
+			Time: e.Updated, Event: journalState, MAC: e.MAC, Hash: e.Hash,
+			Offset: e.Offset, Done: e.Done, Failures: e.Failures,
+			Reburns: e.Reburns, Elapsed: e.Elapsed, Reason: e.Failed,
+		})
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, j.Path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(j.Path))
+}
+
+// syncDir fsync 目錄，確保改名在當機後仍然生效；Windows 不支援對目錄 fsync，直接略過
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (j *Journal) sorted(hash string) []JournalEntry {
+	var list []JournalEntry
+	for _, e := range j.entries {
+		if hash == "" || e.Hash == hash {
+			list = append(list, *e)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].MAC != list[b].MAC {
+			return list[a].MAC < list[b].MAC
+		}
+		return list[a].Hash < list[b].Hash
+	})
+	return list
+}
+
+// write 先寫入並 fsync，再更新記憶體狀態
+func (j *Journal) write(rec journalRecord) error {
+	if j == nil {
+		return nil
+	}
+	rec.Time = time.Now()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return os.ErrClosed
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.apply(rec)
+	return nil
+}
+
+// Checkpoint 記錄已確認的燒錄位置
+func (j *Journal) Checkpoint(mac, hash string, offset int) error {
+	return j.write(journalRecord{Event: journalOffset, MAC: mac, Hash: hash, Offset: offset})
+}
+
+// MarkDone 記錄驗證完成
+func (j *Journal) MarkDone(mac, hash string) error {
+	return j.write(journalRecord{Event: journalDone, MAC: mac, Hash: hash})
+}
+
+// Reset 清除重試紀錄與 FAILED 狀態，燒錄進度保留
+func (j *Journal) Reset(mac, hash string) error {
+	return j.write(journalRecord{Event: journalReset, MAC: mac, Hash: hash})
+}
+
+// Clear 清除該設備的進度 (重燒)，重試紀錄保留
+func (j *Journal) Clear(mac, hash string) error {
+	return j.write(journalRecord{Event: journalClear, MAC: mac, Hash: hash})
+}
+
+// journalBatch 持有 MapMutex 時先收集紀錄，解鎖後再以 Commit 寫入，fsync 不會阻塞掃描器
+type journalBatch []journalRecord
+
+func (b *journalBatch) add(event, mac, hash string) *journalRecord {
+	*b = append(*b, journalRecord{Event: event, MAC: mac, Hash: hash})
+	return &(*b)[len(*b)-1]
+}
+
+// Commit 依序寫入收集的紀錄；遇到錯誤即停止並回傳
+func (j *Journal) Commit(batch journalBatch) error {
+	for _, rec := range batch {
+		if err := j.write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entries 回傳指定 ADS 雜湊的所有紀錄 (hash 為空時回傳全部)
+func (j *Journal) Entries(hash string) []JournalEntry {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.sorted(hash)
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournalCommitSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.jsonl")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const mac, hash = "AA:BB:CC:DD:EE:FF", "h1"
+
+	var batch journalBatch
+	batch.add(journalTime, mac, hash).Elapsed = (90 * time.Second).Milliseconds()
+	batch.add(journalReburn, mac, hash)
+	batch.add(journalFailed, mac, hash).Reason = "budget"
+	if err := j.Checkpoint(mac, hash, 4096); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Commit(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 停工後 (日誌已關閉) 的寫入必須回報錯誤，而不是默默遺失
+	if err := j.Checkpoint(mac, hash, 8192); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("write after close: %v, want os.ErrClosed", err)
+	}
+
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	entries := j.Entries(hash)
+	if len(entries) != 1 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	e := entries[0]
+	if e.Offset != 4096 || e.Reburns != 1 || e.Elapsed != 90000 || e.Failed != "budget" {
+		t.Errorf("entry = %+v", e)
+	}
+}
+
+// 進度以 ADS 內容的雜湊為 key：檔案改名仍可續燒，同名但內容不同的檔案不會沿用舊進度
+func TestProgressKeyedByImageHash(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(filepath.Join(dir, "progress.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	const partial, done = "AA:00:00:00:00:01", "AA:00:00:00:00:02"
+
+	original := filepath.Join(dir, "voice.ads")
+	if err := os.WriteFile(original, syntheticADS(1, 1000, 2, 3000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hash := ParseADSFileTo(io.Discard, original).SHA256
+	if err := j.Checkpoint(partial, hash, 4096); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.MarkDone(done, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	restore := func(path string) *FactoryManager {
+		m := &FactoryManager{
+			Meta:      ParseADSFileTo(io.Discard, path),
+			Journal:   j,
+			DoneMap:   make(map[ProgressKey]bool),
+			OffsetMap: make(map[ProgressKey]int),
+			Budgets:   make(map[ProgressKey]*DeviceBudget),
+		}
+		m.restoreProgress()
+		return m
+	}
+
+	renamed := filepath.Join(dir, "voice-v2.ads")
+	if err := os.Rename(original, renamed); err != nil {
+		t.Fatal(err)
+	}
+	m := restore(renamed)
+	if got := m.OffsetMap[m.progressKey(partial)]; got != 4096 {
+		t.Errorf("renamed file: offset = %d, want 4096", got)
+	}
+	if !m.DoneMap[m.progressKey(done)] {
+		t.Error("renamed file: done device not restored")
+	}
+
+	// 同名的另一個 ADS
+	if err := os.WriteFile(original, syntheticADS(1, 1000, 2, 3001), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m = restore(original)
+	if m.Meta.SHA256 == hash {
+		t.Fatal("different images hashed the same")
+	}
+	if len(m.OffsetMap) != 0 || len(m.DoneMap) != 0 {
+		t.Errorf("other image with the same name restored progress: offsets %v done %v", m.OffsetMap, m.DoneMap)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -9,14 +10,16 @@ import (
 	"sync"
 	"time"
 
+	"BM2/protocol"
+
 	"tinygo.org/x/bluetooth"
 )
 
 // TrackInfo 定義單一音軌資訊
 type TrackInfo struct {
-	ID     uint32
-	Size   uint32
-	Offset uint32
+	ID     uint32 `json:"id"`
+	Size   uint32 `json:"size"`
+	Offset uint32 `json:"offset"`
 }
 
 // FileMeta 定義 ADS 檔案的解析結果
@@ -25,15 +28,26 @@ type FileMeta struct {
 	EncodedData []byte
 	SizeKB      int
 	Tracks      map[int]TrackInfo
+	SHA256      string // RawData 的雜湊 (hex)；進度與報告皆以 (MAC, SHA256) 為 key
+}
+
+// ProgressKey 進度的 key：同一台設備換了 ADS 就是不同的進度
+type ProgressKey struct {
+	MAC  string
+	Hash string
 }
 
 // Job 定義產線任務
 type Job struct {
 	Name          string
+	DasID         string // 名稱中比對到的 TargetID
 	MAC           string
 	CurrentOffset int
 	IsReburn      bool
 	SkipBurn      bool
+	Attempts      int // 先前失敗次數，越少越優先排程
+
+	seq uint64 // JobQueue 排入順序
 }
 
 type Stats struct {
@@ -47,6 +61,45 @@ type Order struct {
 	File      string   `json:"file"`
 	TargetIDs []string `json:"target_ids"`
 	Ports     []string `json:"ports"`
+	MAC       string   `json:"mac,omitempty"` // CANCEL / RESET_DEVICE 指定的設備
+
+	// 選填：主機藍牙虛擬 Port ("BLE:n") 使用的 GATT UUID
+	BLEService string `json:"ble_service,omitempty"`
+	BLEWrite   string `json:"ble_write,omitempty"`
+	BLENotify  string `json:"ble_notify,omitempty"`
+
+	// 選填：指定 Ports 時仍自動加入新插上的 Dongle (Ports 為空時一律自動加入)
+	AutoPorts bool `json:"auto_ports,omitempty"`
+
+	// 選填：燒錄滑動視窗大小 (0 = DefaultWindowSize，1 = 逐包確認)
+	WindowSize int `json:"window_size,omitempty"`
+
+	// 選填：每台設備的重試次數上限 (0 = 不限制)；超過後進入 FAILED，需 RESET_DEVICE 重置
+	MaxReburns  int `json:"max_reburns,omitempty"`
+	MaxReleases int `json:"max_releases,omitempty"`
+	// 選填：失敗作業的累計時間上限 (秒，0 = 不限制)；成功或進行中的作業不計入
+	MaxDeviceSeconds int `json:"max_device_seconds,omitempty"`
+
+	// 選填：複製模式，先讀回此 MAC 的內容作為燒錄來源 (取代 File)
+	CloneFrom string `json:"clone_from,omitempty"`
+
+	// 選填：續燒日誌路徑 (預設為使用者設定目錄下的 BeeMaster/progress.jsonl)
+	Journal string `json:"journal,omitempty"`
+
+	// 選填：燒錄履歷路徑 (預設為使用者設定目錄下的 BeeMaster/history.jsonl)
+	History string `json:"history,omitempty"`
+
+	// HISTORY / EXPORT_HISTORY 查詢條件；日期為 2006-01-02 或 RFC3339，匯出格式為 json 或 csv
+	DasID  string `json:"das_id,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Format string `json:"format,omitempty"`
+	// HISTORY 分頁：由最新往回跳過 Skip 筆，最多回傳 Limit 筆 (0 = 預設 100，上限 1000)
+	Limit int `json:"limit,omitempty"`
+	Skip  int `json:"skip,omitempty"`
+
+	// 選填：指定目錄後，每個作業的收發都會錄成 Trace 檔
+	TraceDir string `json:"trace_dir,omitempty"`
 }
 
 type Response struct {
@@ -55,6 +108,9 @@ type Response struct {
 	Mac     string `json:"mac,omitempty"`
 	Message string `json:"message,omitempty"`
 	Pct     int    `json:"pct,omitempty"`
+	Hash    string `json:"hash,omitempty"` // PROGRESS 對應的 ADS 雜湊
+
+	Data interface{} `json:"data,omitempty"` // 結構化事件內容 (PORT 等)
 }
 
 var (
@@ -63,6 +119,9 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 	if err := adapter.Enable(); err != nil {
 		sendError("SYSTEM", "藍牙啟用失敗: "+err.Error())
 		return
@@ -80,46 +139,232 @@ func listenToFlutter() {
 		}
 
 		if order.Command == "START" {
+			// 先驗證 ADS，有錯誤就不啟動 (也不影響正在執行的工作)
+			if order.CloneFrom == "" && !reportADSValidation(order.File) {
+				continue
+			}
+			// 舊工廠的作業全部結束、Port 都關閉後才啟動新的，避免搶同一個 Dongle
 			if manager != nil {
 				manager.Stop()
 			}
 			manager = NewFactoryManager(order)
-			go manager.Start()
+			manager.Start()
 		} else if order.Command == "STOP" {
+			cancelBackups("")
 			if manager != nil {
 				manager.Stop()
 			}
+		} else if order.Command == "CANCEL" {
+			cancelBackups(order.MAC)
+			if manager != nil {
+				manager.CancelJob(order.MAC)
+			}
+		} else if order.Command == "RESET_DEVICE" {
+			// 操作員確認後重置 FAILED 設備的重試額度；未開工時直接改寫日誌檔
+			var found bool
+			if manager.Running() {
+				found = manager.ResetDevice(order.MAC)
+			} else {
+				var err error
+				if found, err = ResetDeviceInJournal(journalPath(order), order.MAC); err != nil {
+					sendError("SYSTEM", "續燒日誌寫入失敗: "+err.Error())
+					continue
+				}
+			}
+			if !found {
+				sendError("SYSTEM", "找不到設備的重試紀錄: "+order.MAC)
+				continue
+			}
+			sendEvent("DEVICE_RESET", "SYSTEM", order.MAC, nil)
+		} else if order.Command == "LIST_FAILED" {
+			if manager.Running() {
+				sendEvent("FAILED_LIST", "SYSTEM", "", manager.FailedDevices())
+				continue
+			}
+			list, err := FailedDevicesInJournal(journalPath(order))
+			if err != nil {
+				sendError("SYSTEM", "無法讀取續燒日誌: "+err.Error())
+				continue
+			}
+			sendEvent("FAILED_LIST", "SYSTEM", "", list)
+		} else if order.Command == "LIST_PORTS" {
+			dongles, err := EnumerateDongles()
+			if err != nil {
+				sendError("SYSTEM", "列舉序列埠失敗: "+err.Error())
+				continue
+			}
+			sendEvent("PORT_LIST", "SYSTEM", "", dongles)
+		} else if order.Command == "BACKUP" {
+			// 備份：Ports[0] 連線 MAC，讀回的映像存到 File
+			if len(order.Ports) == 0 || order.MAC == "" || order.File == "" {
+				sendError("SYSTEM", "BACKUP 需要 ports、mac 與 file")
+				continue
+			}
+			port := order.Ports[0]
+			if manager != nil && manager.ownsPort(port) {
+				sendError(port, "Port 正在產線使用中，請先停工再備份")
+				continue
+			}
+			ctx, ok := startBackup(order.MAC)
+			if !ok {
+				sendError(port, "設備正在備份中: "+order.MAC)
+				continue
+			}
+			go func(order Order) {
+				defer finishBackup(order.MAC)
+				n, err := BackupToFile(ctx, port, order.MAC, order.File, bleConfig(order))
+				if err != nil {
+					sendError(port, fmt.Sprintf("備份失敗 (%s): %v", order.MAC, err))
+					return
+				}
+				sendEvent("BACKUP", port, "OK", map[string]interface{}{"mac": order.MAC, "file": order.File, "bytes": n})
+			}(order)
+		} else if order.Command == "HISTORY" || order.Command == "EXPORT_HISTORY" {
+			// 查詢燒錄履歷 (可依 mac / das_id / from / to 篩選)；EXPORT_HISTORY 另寫到 File
+			records, err := queryHistory(order)
+			if err != nil {
+				sendError("SYSTEM", fmt.Sprintf("履歷查詢失敗: %v", err))
+				continue
+			}
+			if order.Command == "HISTORY" {
+				page := pageHistory(records, order.Skip, order.Limit)
+				sendEvent("HISTORY", "SYSTEM", fmt.Sprintf("%d", len(records)), map[string]interface{}{
+					"total": len(records), "skip": order.Skip, "records": page,
+				})
+				continue
+			}
+			if order.File == "" {
+				sendError("SYSTEM", "EXPORT_HISTORY 需要 file")
+				continue
+			}
+			if err := exportHistoryFile(order.File, records, order.Format); err != nil {
+				sendError("SYSTEM", fmt.Sprintf("履歷匯出失敗: %v", err))
+				continue
+			}
+			sendEvent("HISTORY_EXPORT", "SYSTEM", "OK", map[string]interface{}{"file": order.File, "records": len(records)})
+		} else if order.Command == "VALIDATE" {
+			reportADSValidation(order.File)
+		} else if order.Command == "HEALTH" {
+			if manager != nil {
+				sendEvent("HEALTH_LIST", "SYSTEM", "", manager.HealthReport())
+			}
 		}
 	}
 }
 
+// reportADSValidation 送出 ADS_REPORT 事件；檔案無法讀取或有 error 時回傳 false
+func reportADSValidation(path string) bool {
+	issues, err := ValidateADSFile(path)
+	if err != nil {
+		sendError("SYSTEM", "無法讀取 ADS 檔案: "+err.Error())
+		return false
+	}
+	return reportADSIssues(issues)
+}
+
+func reportADSIssues(issues []ADSIssue) bool {
+	if issues == nil {
+		issues = []ADSIssue{}
+	}
+	ok := !hasADSErrors(issues)
+	msg := "OK"
+	if !ok {
+		msg = "INVALID"
+	}
+	sendEvent("ADS_REPORT", "SYSTEM", msg, issues)
+	if !ok {
+		sendError("SYSTEM", "ADS 檔案驗證失敗，請修正後再開始")
+	}
+	return ok
+}
+
 // --- 🏭 廠長邏輯 ---
 
 type FactoryManager struct {
 	Config Order
 	Meta   FileMeta
+	BLE    BLEConfig // 主機藍牙 Port 使用的 GATT UUID
 
 	IdlePorts chan string
-	JobQueue  chan Job
+	JobQueue  *JobQueue
+
+	// 熱插拔：ActivePorts 為目前可用的 Port，PooledPorts 代表其 Token 在 IdlePorts 或作業中
+	ActivePorts map[string]bool
+	PooledPorts map[string]bool
+	Health      map[string]*PortHealth // 各 Port 的健康度統計與隔離狀態
+	PortMutex   sync.Mutex
 
 	ProcessingMap map[string]bool
-	DoneMap       map[string]bool
-	OffsetMap     map[string]int
+	DoneMap       map[ProgressKey]bool
+	OffsetMap     map[ProgressKey]int
+	CancelledMap  map[string]bool               // 操作員取消的設備，本次工作不再掃描
+	Budgets       map[ProgressKey]*DeviceBudget // 各設備已使用的重試額度 (含先前工作)
+	JobCancels    map[string]context.CancelFunc // 進行中作業的取消函式
 	MapMutex      sync.Mutex
 
-	Quit chan bool
+	// 進度同步寫入日誌；每個作業結果寫入履歷
+	Journal *Journal
+	History *HistoryStore
+
+	// STOP 時取消，所有作業中的等待都會在有限時間內中斷
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// 由 spawn 啟動的 goroutine (掃描、派工、作業、自我測試)；Stop 等它們全部結束
+	routines sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// journalPath 指令指定的續燒日誌路徑，未指定時使用預設路徑 (無法取得時為空字串)
+func journalPath(order Order) string {
+	if order.Journal != "" {
+		return order.Journal
+	}
+	path, _ := defaultJournalPath()
+	return path
+}
+
+// spawn 啟動受 Stop 追蹤的 goroutine；只能在 Start 或其他受追蹤的 goroutine 中呼叫
+func (m *FactoryManager) spawn(f func()) {
+	m.routines.Add(1)
+	go func() {
+		defer m.routines.Done()
+		f()
+	}()
 }
 
 func NewFactoryManager(order Order) *FactoryManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	var meta FileMeta
+	if order.CloneFrom == "" {
+		meta = ParseADSFile(order.File)
+	}
+	var journal *Journal
+	if path := journalPath(order); path != "" {
+		var err error
+		if journal, err = OpenJournal(path); err != nil {
+			sendLog("SYSTEM", fmt.Sprintf("⚠️ 無法開啟續燒日誌 (%v)，進度只保留在記憶體", err))
+		}
+	}
 	return &FactoryManager{
 		Config:        order,
-		Meta:          ParseADSFile(order.File),
-		IdlePorts:     make(chan string, len(order.Ports)),
-		JobQueue:      make(chan Job, 100),
+		BLE:           bleConfig(order),
+		Meta:          meta,
+		IdlePorts:     make(chan string, maxPorts),
+		ActivePorts:   make(map[string]bool),
+		PooledPorts:   make(map[string]bool),
+		Health:        make(map[string]*PortHealth),
+		JobQueue:      NewJobQueue(),
 		ProcessingMap: make(map[string]bool),
-		DoneMap:       make(map[string]bool),
-		OffsetMap:     make(map[string]int),
-		Quit:          make(chan bool),
+		DoneMap:       make(map[ProgressKey]bool),
+		OffsetMap:     make(map[ProgressKey]int),
+		CancelledMap:  make(map[string]bool),
+		Budgets:       make(map[ProgressKey]*DeviceBudget),
+		Journal:       journal,
+		History:       openHistory(order.History),
+		JobCancels:    make(map[string]context.CancelFunc),
+		ctx:           ctx,
+		cancel:        cancel,
 	}
 }
 
@@ -127,44 +372,90 @@ func (m *FactoryManager) Start() {
 	//sendLog("SYSTEM", fmt.Sprintf("🏭 工廠啟動，目標 ID: %v", m.Config.TargetIDs))
 
 	for _, port := range m.Config.Ports {
-		m.IdlePorts <- port
+		m.addPort(PortInfo{Name: port})
+	}
+
+	m.spawn(m.RunPortWatcher)
+	if m.Config.CloneFrom != "" {
+		// 複製模式：來源讀取並驗證成功後才開始掃描
+		m.spawn(func() {
+			if m.loadCloneSource() {
+				m.restoreProgress()
+				m.spawn(m.RunGlobalScanner)
+				m.spawn(m.RunDispatcher)
+			}
+		})
+		return
+	}
+	m.restoreProgress()
+	m.spawn(m.RunGlobalScanner)
+	m.spawn(m.RunDispatcher)
+}
+
+// windowSize 未指定時使用 DefaultWindowSize
+func (m *FactoryManager) windowSize() int {
+	if m.Config.WindowSize == 0 {
+		return DefaultWindowSize
 	}
+	return m.Config.WindowSize
+}
 
-	go m.RunGlobalScanner()
-	go m.RunDispatcher()
+// Running 工作是否仍在進行 (尚未 STOP)；nil 安全
+func (m *FactoryManager) Running() bool {
+	return m != nil && m.ctx.Err() == nil
 }
 
+// Stop 取消所有作業並等待它們結束 (Port 關閉、結果寫入日誌) 後才關閉日誌；可重複呼叫
 func (m *FactoryManager) Stop() {
-	close(m.Quit)
-	sendLog("SYSTEM", "🛑 工廠已停工")
+	m.stopOnce.Do(func() {
+		m.cancel() // 掃描器由 scanBLE 負責停止
+		m.routines.Wait()
+		forgetBLEAddresses()
+		if err := m.Journal.Close(); err != nil {
+			sendLog("SYSTEM", fmt.Sprintf("⚠️ 續燒日誌關閉失敗: %v", err))
+		}
+		sendLog("SYSTEM", "🛑 工廠已停工")
+	})
+}
+
+// CancelJob 取消單一設備的作業；進行中的作業會中斷並歸還 Port
+func (m *FactoryManager) CancelJob(mac string) {
+	m.MapMutex.Lock()
+	defer m.MapMutex.Unlock()
+	m.CancelledMap[mac] = true
+	if cancel, ok := m.JobCancels[mac]; ok {
+		cancel()
+	}
+	sendLog("SYSTEM", fmt.Sprintf("⏹️ 已取消作業: %s", mac))
 }
 
 func (m *FactoryManager) RunGlobalScanner() {
+	if m.ctx.Err() != nil {
+		return
+	}
 	sendLog("SYSTEM", "👀 掃描器啟動...")
-	adapter.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
-		select {
-		case <-m.Quit:
-			adapter.StopScan()
+	err := scanBLE(m.ctx, func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
+		if m.ctx.Err() != nil {
 			return
-		default:
 		}
 
 		name := result.LocalName()
 		mac := result.Address.String()
-
-		matched := false
+		dasID := ""
 		for _, target := range m.Config.TargetIDs {
 			if name != "" && strings.Contains(name, target) {
-				matched = true
+				dasID = target
 				break
 			}
 		}
-		if !matched {
+		if dasID == "" || m.isCloneSource(mac) {
 			return
 		}
+		rememberBLEAddress(mac, result.Address)
 
 		m.MapMutex.Lock()
-		if m.DoneMap[mac] || m.ProcessingMap[mac] {
+		key := m.progressKey(mac)
+		if m.DoneMap[key] || m.ProcessingMap[mac] || m.CancelledMap[mac] || m.isFailed(mac) || m.inBackoff(mac) {
 			m.MapMutex.Unlock()
 			return
 		}
@@ -172,27 +463,46 @@ func (m *FactoryManager) RunGlobalScanner() {
 		m.ProcessingMap[mac] = true
 		job := Job{
 			Name:          name,
+			DasID:         dasID,
 			MAC:           mac,
-			CurrentOffset: m.OffsetMap[mac],
+			CurrentOffset: m.OffsetMap[key],
 			SkipBurn:      false,
+			Attempts:      m.jobAttempts(mac),
 		}
-		m.JobQueue <- job
+		m.JobQueue.Push(job)
 		m.MapMutex.Unlock()
 	})
+	if err != nil && m.ctx.Err() == nil {
+		sendError("SYSTEM", "藍牙掃描失敗: "+err.Error())
+	}
 }
 
 func (m *FactoryManager) RunDispatcher() {
+	for {
+		job, ok := m.JobQueue.Pop(m.ctx)
+		if !ok {
+			return
+		}
+		port, ok := m.nextPort()
+		if !ok {
+			return
+		}
+		// 等待 Port 期間可能排入了更優先的設備
+		job = m.JobQueue.Exchange(job)
+		m.spawn(func() { m.RunWorker(port, job) })
+	}
+}
+
+// nextPort 取出下一個仍在使用中的閒置 Port；已退役的 Port 直接丟棄
+func (m *FactoryManager) nextPort() (string, bool) {
 	for {
 		select {
-		case job := <-m.JobQueue:
-			select {
-			case port := <-m.IdlePorts:
-				go m.RunWorker(port, job)
-			case <-m.Quit:
-				return
+		case port := <-m.IdlePorts:
+			if m.acquirePort(port) {
+				return port, true
 			}
-		case <-m.Quit:
-			return
+		case <-m.ctx.Done():
+			return "", false
 		}
 	}
 }
@@ -211,23 +521,65 @@ func (m *FactoryManager) RunWorker(port string, job Job) {
 		sendLog(port, fmt.Sprintf("啟動作業: %s", job.Name))
 	}
 
-	sendProgress(port, job.MAC, 0) // 立即變色
+	sendProgress(port, job.MAC, m.Meta.SHA256, 0) // 立即變色
 
-	t := NewSerialAdaptor(port)
+	// Trace 直接包住實際傳輸以錄到原始位元組；健康度統計在最外層，所有呼叫都會經過
+	t := newTransport(port, m.BLE)
+	if m.Config.TraceDir != "" {
+		if rec, err := NewTraceRecorder(t, m.Config.TraceDir, port, job.MAC); err == nil {
+			defer rec.Close()
+			t = rec
+		} else {
+			sendLog(port, fmt.Sprintf("⚠️ 無法建立 Trace 檔: %v", err))
+		}
+	}
+	health := newHealthTransport(t)
+	t = health
 
 	const (
-		SUCCESS = 0
-		REBURN  = 1
-		RELEASE = 2
+		SUCCESS   = 0
+		REBURN    = 1
+		RELEASE   = 2
+		CANCELLED = 3
 	)
 
+	// 每個作業各自的 Context：STOP 或 CANCEL 都會中斷
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	m.MapMutex.Lock()
+	if m.CancelledMap[job.MAC] {
+		cancel()
+	}
+	m.JobCancels[job.MAC] = cancel
+	m.MapMutex.Unlock()
+
+	record := BurnRecord{
+		MAC:         job.MAC,
+		Name:        job.Name,
+		DasID:       job.DasID,
+		Port:        port,
+		ImageHash:   m.Meta.SHA256,
+		ImageFile:   m.Config.File,
+		Started:     time.Now(),
+		ResumedFrom: job.CurrentOffset,
+		Tracks:      sortedTracks(m.Meta.Tracks),
+	}
+	if m.Config.CloneFrom != "" {
+		record.ImageFile = "clone:" + m.Config.CloneFrom
+	}
+
 	status := func() int {
+		// 中斷時不送重啟：Checksum 仍為 0xFFFF，設備停在「未完成」狀態，Offset 已存檔可續燒
 		defer t.Disconnect()
 
 		// --- 階段 1: 燒錄 ---
 		if !job.SkipBurn {
 			// 執行燒錄
-			if !PerformFlash(t, job.MAC, m.Meta, prefix, &job.CurrentOffset) {
+			checkpoint := func(offset int) { m.updateProgress(job.MAC, offset, false) }
+			endFlash := record.phase("FLASH")
+			flashed := PerformFlash(ctx, t, job.MAC, m.Meta, prefix, &job.CurrentOffset, m.windowSize(), checkpoint)
+			endFlash(flashed)
+			if !flashed {
 				m.updateProgress(job.MAC, job.CurrentOffset, false)
 				sendLog(port, "❌ 燒錄失敗 (Write Fail)")
 				return RELEASE
@@ -239,34 +591,59 @@ func (m *FactoryManager) RunWorker(port string, job Job) {
 			m.updateProgress(job.MAC, totalSize, false)
 
 			// 執行 Checksum 驗證與重啟
-			if !VerifyChecksumAndReboot(t, m.Meta, prefix) {
+			endChecksum := record.phase("CHECKSUM")
+			rebooted := VerifyChecksumAndReboot(ctx, t, m.Meta, prefix)
+			endChecksum(rebooted)
+			if !rebooted {
 				// 如果這裡失敗 (例如重啟指令沒回應)，釋放任務 (RELEASE)
 				// 因為上面已經存檔了，所以下一個人會直接跳過燒錄，符合邏輯
 				return RELEASE
 			}
 
-			sendProgress(port, job.MAC, 100)
+			sendProgress(port, job.MAC, m.Meta.SHA256, 100)
 			t.Disconnect()
 			sendLog(port, "🛌 設備重啟，等待 15s...")
-			time.Sleep(15 * time.Second)
+			endWait := record.phase("REBOOT_WAIT")
+			if sleepCtx(ctx, 15*time.Second) != nil {
+				endWait(false)
+				return CANCELLED
+			}
+			endWait(true)
 		}
 
 		// --- 階段 2: 驗證 ---
+		endConnect := record.phase("CONNECT")
 		connected := false
 		for r := 0; r < 5; r++ {
-			if err := t.Connect(job.MAC); err == nil {
+			if err := t.Connect(ctx, job.MAC); err == nil {
 				connected = true
 				break
 			}
-			time.Sleep(2 * time.Second)
+			if sleepCtx(ctx, 2*time.Second) != nil {
+				return CANCELLED
+			}
 		}
+		endConnect(connected)
 		if !connected {
 			sendLog(port, "⚠️ 驗證階段連線超時，釋放任務")
 			return RELEASE
 		}
 
 		// 呼叫比對函式
-		match, err := PerformFinalDebugCheck(t, m.Meta, prefix)
+		endVerify := record.phase("VERIFY")
+		match, err := PerformFinalDebugCheck(ctx, t, m.Meta, prefix)
+		endVerify(err == nil && match)
+		if ctx.Err() != nil {
+			return CANCELLED
+		}
+		switch {
+		case err != nil:
+			record.Verification = "READ_ERROR"
+		case match:
+			record.Verification = "MATCH"
+		default:
+			record.Verification = "MISMATCH"
+		}
 
 		// 🛑 情況 A: 讀取過程發生錯誤 (Timeout, I/O Error)
 		// 動作: 釋放 (RELEASE)，保留進度 (因為已經存檔為 100% 了)，換人讀讀看
@@ -284,48 +661,150 @@ func (m *FactoryManager) RunWorker(port string, job Job) {
 		}
 
 		// ✅ 情況 C: 成功
-		var f uint16
-		t.SendCmd(0x20, &f, []byte{0xE4, 0x00, 0x01})
+		SendRequest(t, protocol.Reboot{})
 		sendLog(port, "✅ 任務完成")
 
 		// 任務完成，標記 Done = true
 		m.updateProgress(job.MAC, 0, true)
 		return SUCCESS
 	}()
+	if status != SUCCESS && ctx.Err() != nil {
+		status = CANCELLED
+	}
 
+	switch status {
+	case SUCCESS:
+		record.Outcome = "SUCCESS"
+	case REBURN:
+		record.Outcome = "REBURN"
+	case RELEASE:
+		record.Outcome = "RELEASE"
+	default:
+		record.Outcome = "CANCELLED"
+	}
+	record.Finished = time.Now()
+	record.FinalOffset = job.CurrentOffset
+	record.ConnectAttempts = health.stats.ConnectAttempts
+	record.ACKTimeouts = health.stats.ACKTimeouts
+	record.Retransmits = health.stats.Retransmits
+
+	var batch journalBatch
 	m.MapMutex.Lock()
+	delete(m.JobCancels, job.MAC)
+	if status == REBURN || status == RELEASE {
+		// 額度用盡：不再重燒或重新掃描，等待操作員 RESET_DEVICE
+		reason := m.chargeBudget(job.MAC, record.Finished.Sub(record.Started), status == REBURN, status == RELEASE, &batch)
+		if reason != "" {
+			m.failDevice(port, job.MAC, reason, &batch)
+			record.Outcome, record.FailReason = "FAILED", reason
+			status = CANCELLED
+		}
+	}
 	if status == REBURN {
-		// 重燒狀態：重置 Offset，允許燒錄，丟回佇列
+		// 重燒狀態：重置 Offset，允許燒錄，退避期滿後丟回佇列 (期間仍標記為處理中)
 		job.CurrentOffset = 0
 		job.SkipBurn = false
-		go func() { m.JobQueue <- job }()
-		delete(m.ProcessingMap, job.MAC)
+		job.Attempts = m.jobAttempts(job.MAC)
+		delay := m.scheduleRetry(job.MAC)
+		m.requeueAfter(job, delay)
+		sendLog(port, fmt.Sprintf("♻️ 重燒任務，%s 後重新排入", delay.Round(time.Second)))
 	} else if status == RELEASE {
 		// 釋放狀態：從 ProcessingMap 移除，讓 GlobalScanner 可以再次掃描到它
 		// 因為我們有存 Offset，所以下次被掃到時會接續進度
 		delete(m.ProcessingMap, job.MAC)
-		sendLog(port, "♻️ 釋放任務")
+		delay := m.scheduleRetry(job.MAC)
+		sendLog(port, fmt.Sprintf("♻️ 釋放任務，%s 後再重試", delay.Round(time.Second)))
 	} else if status == SUCCESS {
 		// 成功狀態
 		delete(m.ProcessingMap, job.MAC)
+	} else if status == CANCELLED {
+		// 取消 (或 FAILED) 狀態：Offset 已保留，Port 直接歸還
+		delete(m.ProcessingMap, job.MAC)
+		sendLog(port, "⏹️ 作業已中斷，Port 已釋放")
 	}
 	m.MapMutex.Unlock()
+	m.journalError(job.MAC, m.Journal.Commit(batch))
+
+	if err := m.History.Append(record); err != nil {
+		sendLog(port, fmt.Sprintf("⚠️ 無法寫入燒錄履歷: %v", err))
+	}
+
+	// 安全帽造成的 RELEASE (不在範圍內、拒絕連線、ACK 逾時) 不算 Dongle 的失敗
+	m.recordHealth(port, health.stats, status == RELEASE && health.stats.DongleFault)
+	m.releasePort(port)
+}
 
-	m.IdlePorts <- port
+// newTransport 依 Port 名稱選擇傳輸方式：COM Port 走 Dongle，"BLE:n" 走主機藍牙
+func newTransport(port string, ble BLEConfig) Transporter {
+	if isBLEPort(port) {
+		return NewBLETransport(port, ble)
+	}
+	return NewSerialAdaptor(port)
 }
 
+// updateProgress 日誌在 MapMutex 之外寫入，fsync 不會阻塞掃描器
 func (m *FactoryManager) updateProgress(mac string, offset int, done bool) {
 	m.MapMutex.Lock()
-	defer m.MapMutex.Unlock()
-	m.OffsetMap[mac] = offset
-	m.DoneMap[mac] = done
+	key := m.progressKey(mac)
+	m.OffsetMap[key] = offset
+	m.DoneMap[key] = done
+	m.MapMutex.Unlock()
+	if done {
+		m.journalError(mac, m.Journal.MarkDone(mac, key.Hash))
+	} else {
+		m.journalError(mac, m.Journal.Checkpoint(mac, key.Hash, offset))
+	}
 }
 
 func (m *FactoryManager) clearProgress(mac string) {
+	m.MapMutex.Lock()
+	key := m.progressKey(mac)
+	delete(m.OffsetMap, key)
+	delete(m.DoneMap, key)
+	m.MapMutex.Unlock()
+	m.journalError(mac, m.Journal.Clear(mac, key.Hash))
+}
+
+// journalError 日誌寫入失敗時提醒操作員：進度仍保留在記憶體，但重開程式後無法續燒
+func (m *FactoryManager) journalError(mac string, err error) {
+	if err != nil {
+		sendLog("SYSTEM", fmt.Sprintf("⚠️ 續燒日誌寫入失敗 (%s): %v", mac, err))
+	}
+}
+
+func (m *FactoryManager) progressKey(mac string) ProgressKey {
+	return ProgressKey{MAC: mac, Hash: m.Meta.SHA256}
+}
+
+// restoreProgress 以目前 ADS 的雜湊從日誌載回進度；不同 ADS 的紀錄不會套用
+func (m *FactoryManager) restoreProgress() {
+	entries := m.Journal.Entries(m.Meta.SHA256)
+
 	m.MapMutex.Lock()
 	defer m.MapMutex.Unlock()
-	delete(m.OffsetMap, mac)
-	delete(m.DoneMap, mac)
+	done, partial, failed := 0, 0, 0
+	for _, e := range entries {
+		key := ProgressKey{MAC: e.MAC, Hash: e.Hash}
+		if e.hasBudget() {
+			m.Budgets[key] = e.budget()
+			if e.Failed != "" {
+				failed++
+			}
+		}
+		if e.Done {
+			m.DoneMap[key] = true
+			done++
+		} else if e.Offset > 0 {
+			m.OffsetMap[key] = e.Offset
+			partial++
+		}
+	}
+	if done+partial > 0 {
+		sendLog("SYSTEM", fmt.Sprintf("📒 已從日誌載入進度：%d 台完成、%d 台可續燒", done, partial))
+	}
+	if failed > 0 {
+		sendLog("SYSTEM", fmt.Sprintf("⛔ %d 台設備為 FAILED，需 RESET_DEVICE 才會重新燒錄", failed))
+	}
 }
 
 // --- 🔥 JSON 適配器 (讓 flash.go/debug_reader.go 也能輸出 JSON) ---
@@ -335,16 +814,20 @@ func reportLog(format string, a ...interface{}) {
 	json.NewEncoder(os.Stdout).Encode(Response{Type: "LOG", Message: msg})
 }
 
-func reportProgress(mac string, pct int) {
-	json.NewEncoder(os.Stdout).Encode(Response{Type: "PROGRESS", Mac: mac, Pct: pct})
+func reportProgress(mac, hash string, pct int) {
+	json.NewEncoder(os.Stdout).Encode(Response{Type: "PROGRESS", Mac: mac, Hash: hash, Pct: pct})
 }
 
 func sendLog(port, msg string) {
 	json.NewEncoder(os.Stdout).Encode(Response{Type: "LOG", Port: port, Message: msg})
 }
 
-func sendProgress(port, mac string, pct int) {
-	json.NewEncoder(os.Stdout).Encode(Response{Type: "PROGRESS", Port: port, Mac: mac, Pct: pct})
+func sendProgress(port, mac, hash string, pct int) {
+	json.NewEncoder(os.Stdout).Encode(Response{Type: "PROGRESS", Port: port, Mac: mac, Hash: hash, Pct: pct})
+}
+
+func sendEvent(typ, port, msg string, data interface{}) {
+	json.NewEncoder(os.Stdout).Encode(Response{Type: typ, Port: port, Message: msg, Data: data})
 }
 
 func sendError(port, msg string) {
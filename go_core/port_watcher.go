@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// ==========================================
+// Dongle 偵測與熱插拔
+// ==========================================
+
+// DongleVID Silicon Labs CP210x (與 Flutter ComScanner 相同)
+const DongleVID = "10C4"
+
+const (
+	portWatchInterval = 2 * time.Second
+	maxPorts          = 64 // IdlePorts 容量上限
+)
+
+// PortInfo 回報給 UI 的序列埠資訊
+type PortInfo struct {
+	Name         string `json:"name"`
+	VID          string `json:"vid,omitempty"`
+	PID          string `json:"pid,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	Product      string `json:"product,omitempty"`
+}
+
+// EnumerateDongles 列出所有 VID 為 CP210x 的 USB 序列埠
+func EnumerateDongles() ([]PortInfo, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+	dongles := []PortInfo{}
+	for _, p := range ports {
+		if p.IsUSB && strings.EqualFold(p.VID, DongleVID) {
+			dongles = append(dongles, toPortInfo(p))
+		}
+	}
+	sort.Slice(dongles, func(i, j int) bool { return dongles[i].Name < dongles[j].Name })
+	return dongles, nil
+}
+
+func toPortInfo(p *enumerator.PortDetails) PortInfo {
+	return PortInfo{
+		Name:         p.Name,
+		VID:          strings.ToUpper(p.VID),
+		PID:          strings.ToUpper(p.PID),
+		SerialNumber: p.SerialNumber,
+		Product:      p.Product,
+	}
+}
+
+// portPathExists 列舉不到的本機 Port (例如 /dev/pts/N) 以檔案是否存在判斷
+func portPathExists(port string) bool {
+	if !strings.HasPrefix(port, "/") {
+		return false
+	}
+	_, err := os.Stat(port)
+	return err == nil
+}
+
+// isLocalPort 本機 COM Port 才需要熱插拔偵測；網路與主機藍牙 Port 視為常駐
+func isLocalPort(port string) bool {
+	return !isTCPPort(port) && !isBLEPort(port)
+}
+
+// autoPorts 操作員指定 Ports 時只用這些 Port，除非另外要求 auto_ports；未指定時加入所有偵測到的 Dongle
+func (m *FactoryManager) autoPorts() bool {
+	return m.Config.AutoPorts || len(m.Config.Ports) == 0
+}
+
+// RunPortWatcher 定期比對實際插著的 Dongle，新增或退役 Port
+func (m *FactoryManager) RunPortWatcher() {
+	ticker := time.NewTicker(portWatchInterval)
+	defer ticker.Stop()
+	for {
+		m.syncPorts()
+		select {
+		case <-ticker.C:
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *FactoryManager) syncPorts() {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return
+	}
+	present := make(map[string]PortInfo)
+	for _, p := range ports {
+		present[p.Name] = toPortInfo(p)
+	}
+
+	wanted := make(map[string]PortInfo)
+	for _, port := range m.Config.Ports {
+		if info, ok := present[port]; ok {
+			wanted[port] = info
+		} else if !isLocalPort(port) || portPathExists(port) {
+			wanted[port] = PortInfo{Name: port}
+		}
+	}
+	if m.autoPorts() {
+		for name, info := range present {
+			if strings.EqualFold(info.VID, DongleVID) {
+				wanted[name] = info
+			}
+		}
+	}
+
+	for _, info := range wanted {
+		m.addPort(info)
+	}
+
+	m.PortMutex.Lock()
+	var retired []string
+	for port, active := range m.ActivePorts {
+		if _, ok := wanted[port]; active && !ok {
+			retired = append(retired, port)
+		}
+	}
+	m.PortMutex.Unlock()
+	for _, port := range retired {
+		m.retirePort(port)
+	}
+}
+
+// addPort 啟用 Port；若它的 Token 不在池中就放回 IdlePorts
+func (m *FactoryManager) addPort(info PortInfo) {
+	m.PortMutex.Lock()
+	if m.ActivePorts[info.Name] {
+		m.PortMutex.Unlock()
+		return
+	}
+	m.ActivePorts[info.Name] = true
+	// 隔離中的 Port 由自我測試通過後再放回
+	push := !m.PooledPorts[info.Name] && !m.isQuarantined(info.Name)
+	if push {
+		m.PooledPorts[info.Name] = true
+	}
+	m.PortMutex.Unlock()
+
+	if push && !m.offerPort(info.Name) {
+		return
+	}
+	sendEvent("PORT", info.Name, "ADDED", info)
+	sendLog("SYSTEM", fmt.Sprintf("🔌 新增 Dongle: %s", info.Name))
+}
+
+// retirePort 停用 Port；閒置中的 Token 由 Dispatcher 取出時丟棄，作業中的由 releasePort 回收
+func (m *FactoryManager) retirePort(port string) {
+	m.PortMutex.Lock()
+	if !m.ActivePorts[port] {
+		m.PortMutex.Unlock()
+		return
+	}
+	delete(m.ActivePorts, port)
+	m.PortMutex.Unlock()
+
+	sendEvent("PORT", port, "REMOVED", PortInfo{Name: port})
+	sendLog("SYSTEM", fmt.Sprintf("🔌 Dongle 已移除: %s", port))
+}
+
+// ownsPort 工作進行中且 Port 由本工廠管理
+func (m *FactoryManager) ownsPort(port string) bool {
+	if m.ctx.Err() != nil {
+		return false
+	}
+	m.PortMutex.Lock()
+	defer m.PortMutex.Unlock()
+	return m.ActivePorts[port]
+}
+
+// acquirePort 檢查從 IdlePorts 取出的 Port 是否仍可使用
+func (m *FactoryManager) acquirePort(port string) bool {
+	m.PortMutex.Lock()
+	defer m.PortMutex.Unlock()
+	if m.ActivePorts[port] && !m.isQuarantined(port) {
+		return true
+	}
+	delete(m.PooledPorts, port)
+	return false
+}
+
+// releasePort 作業結束後歸還 Port；已退役或隔離中的 Port 不再放回
+func (m *FactoryManager) releasePort(port string) {
+	m.PortMutex.Lock()
+	active := m.ActivePorts[port] && !m.isQuarantined(port)
+	if !active {
+		delete(m.PooledPorts, port)
+	}
+	m.PortMutex.Unlock()
+	if active {
+		m.offerPort(port)
+	}
+}
+
+// offerPort 不阻塞地把 Token 放回 IdlePorts；已滿時停用該 Port，由 RunPortWatcher 之後重新加入
+func (m *FactoryManager) offerPort(port string) bool {
+	select {
+	case m.IdlePorts <- port:
+		return true
+	default:
+	}
+	m.PortMutex.Lock()
+	delete(m.PooledPorts, port)
+	delete(m.ActivePorts, port)
+	m.PortMutex.Unlock()
+	sendLog("SYSTEM", fmt.Sprintf("⚠️ 閒置 Port 已達上限 (%d)，暫不使用: %s", maxPorts, port))
+	return false
+}
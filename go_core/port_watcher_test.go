@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+// 操作員指定的 Ports 不會被自動偵測覆蓋，除非明確要求 auto_ports
+func TestAutoPorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		order Order
+		want  bool
+	}{
+		{"no ports", Order{}, true},
+		{"explicit ports", Order{Ports: []string{"COM3"}}, false},
+		{"explicit ports with auto_ports", Order{Ports: []string{"COM3"}, AutoPorts: true}, true},
+	}
+	for _, tt := range tests {
+		m := &FactoryManager{Config: tt.order}
+		if got := m.autoPorts(); got != tt.want {
+			t.Errorf("%s: autoPorts = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package protocol 定義安全帽 / Dongle 指令集 (Opcode) 的編碼與解碼。
+//
+// 每個指令都有對應的結構，Encode 產生放進 0x25 封包的 Payload，
+// Decode 則從 Payload 還原結構。封包外框 (Header / Checksum) 不在此處理。
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 封包 Target
+const (
+	TargetHelmet = 0x20 // 轉送給安全帽的指令
+	TargetMode   = 0x21 // Dongle 模式切換
+	TargetDongle = 0x24 // Dongle 本身的指令
+)
+
+// Opcode
+const (
+	OpStopScan   = 0x83
+	OpConnect    = 0x85
+	OpReboot     = 0xE4
+	OpUnlock     = 0xE6
+	OpWriteAudio = 0xC5
+	OpRead       = 0xC6
+	OpReadReply  = 0xC7
+)
+
+// ModePassthrough 切換到透傳模式 (0x21 0x01)
+const ModePassthrough = 0x01
+
+// ErrShortPayload Payload 長度不足
+var ErrShortPayload = errors.New("protocol: payload too short")
+
+// Request 所有可送出的指令
+type Request interface {
+	Target() byte
+	Encode() []byte
+}
+
+func checkOpcode(payload []byte, op byte, minLen int) error {
+	if len(payload) < minLen {
+		return ErrShortPayload
+	}
+	if payload[0] != op {
+		return fmt.Errorf("protocol: opcode 0x%02X, want 0x%02X", payload[0], op)
+	}
+	return nil
+}
+
+// --- 0x83 停止掃描 ---
+
+type StopScan struct{}
+
+func (StopScan) Target() byte   { return TargetDongle }
+func (StopScan) Encode() []byte { return []byte{OpStopScan, 0x00} }
+
+func DecodeStopScan(payload []byte) (StopScan, error) {
+	return StopScan{}, checkOpcode(payload, OpStopScan, 2)
+}
+
+// --- 0x85 連線 ---
+
+// Connect MAC 以一般書寫順序保存，編碼時反轉為 Little Endian
+type Connect struct {
+	MAC [6]byte
+}
+
+// ParseMAC 解析 "AA:BB:CC:DD:EE:FF" 或 "AABBCCDDEEFF"
+func ParseMAC(s string) ([6]byte, error) {
+	var mac [6]byte
+	clean := strings.ReplaceAll(strings.TrimSpace(s), ":", "")
+	b, err := hex.DecodeString(clean)
+	if err != nil {
+		return mac, fmt.Errorf("invalid mac: %v", err)
+	}
+	if len(b) != 6 {
+		return mac, fmt.Errorf("invalid mac: %q", s)
+	}
+	copy(mac[:], b)
+	return mac, nil
+}
+
+func (Connect) Target() byte { return TargetDongle }
+
+func (c Connect) Encode() []byte {
+	payload := []byte{OpConnect}
+	for i := len(c.MAC) - 1; i >= 0; i-- {
+		payload = append(payload, c.MAC[i])
+	}
+	return payload
+}
+
+func DecodeConnect(payload []byte) (Connect, error) {
+	var c Connect
+	if err := checkOpcode(payload, OpConnect, 7); err != nil {
+		return c, err
+	}
+	for i := 0; i < 6; i++ {
+		c.MAC[i] = payload[6-i]
+	}
+	return c, nil
+}
+
+// --- 0x21 模式切換 ---
+
+type ModeSwitch struct {
+	Mode byte
+}
+
+func (ModeSwitch) Target() byte     { return TargetMode }
+func (m ModeSwitch) Encode() []byte { return []byte{m.Mode} }
+
+func DecodeModeSwitch(payload []byte) (ModeSwitch, error) {
+	if len(payload) < 1 {
+		return ModeSwitch{}, ErrShortPayload
+	}
+	return ModeSwitch{Mode: payload[0]}, nil
+}
+
+// --- 0xE6 解鎖 (Set Operation Mode Engineering) ---
+
+type Unlock struct{}
+
+func (Unlock) Target() byte   { return TargetHelmet }
+func (Unlock) Encode() []byte { return []byte{OpUnlock, 0x01} }
+
+func DecodeUnlock(payload []byte) (Unlock, error) {
+	return Unlock{}, checkOpcode(payload, OpUnlock, 2)
+}
+
+// --- 0xE4 重啟 ---
+
+type Reboot struct{}
+
+func (Reboot) Target() byte   { return TargetHelmet }
+func (Reboot) Encode() []byte { return []byte{OpReboot, 0x00, 0x01} }
+
+func DecodeReboot(payload []byte) (Reboot, error) {
+	return Reboot{}, checkOpcode(payload, OpReboot, 3)
+}
+
+// --- 0xC5 寫入音訊 ---
+
+// WriteAudio 格式: 0xC5 | offset(4) | len(2) | data
+type WriteAudio struct {
+	Offset uint32
+	Data   []byte
+}
+
+func (WriteAudio) Target() byte { return TargetHelmet }
+
+func (w WriteAudio) Encode() []byte {
+	payload := make([]byte, 7, 7+len(w.Data))
+	payload[0] = OpWriteAudio
+	binary.LittleEndian.PutUint32(payload[1:5], w.Offset)
+	binary.LittleEndian.PutUint16(payload[5:7], uint16(len(w.Data)))
+	return append(payload, w.Data...)
+}
+
+func DecodeWriteAudio(payload []byte) (WriteAudio, error) {
+	if err := checkOpcode(payload, OpWriteAudio, 7); err != nil {
+		return WriteAudio{}, err
+	}
+	n := int(binary.LittleEndian.Uint16(payload[5:7]))
+	if len(payload) < 7+n {
+		return WriteAudio{}, ErrShortPayload
+	}
+	return WriteAudio{
+		Offset: binary.LittleEndian.Uint32(payload[1:5]),
+		Data:   payload[7 : 7+n],
+	}, nil
+}
+
+// --- 0xC6 讀取 ---
+
+// Read 格式: 0xC6 | offset(4) | size(2)
+type Read struct {
+	Offset uint32
+	Size   uint16
+}
+
+func (Read) Target() byte { return TargetHelmet }
+
+func (r Read) Encode() []byte {
+	payload := make([]byte, 7)
+	payload[0] = OpRead
+	binary.LittleEndian.PutUint32(payload[1:5], r.Offset)
+	binary.LittleEndian.PutUint16(payload[5:7], r.Size)
+	return payload
+}
+
+func DecodeRead(payload []byte) (Read, error) {
+	if err := checkOpcode(payload, OpRead, 7); err != nil {
+		return Read{}, err
+	}
+	return Read{
+		Offset: binary.LittleEndian.Uint32(payload[1:5]),
+		Size:   binary.LittleEndian.Uint16(payload[5:7]),
+	}, nil
+}
+
+// --- 0xC7 讀取回傳 ---
+
+// ReadReply 格式: 0xC7 | data
+type ReadReply struct {
+	Data []byte
+}
+
+func (r ReadReply) Encode() []byte {
+	return append([]byte{OpReadReply}, r.Data...)
+}
+
+func DecodeReadReply(payload []byte) (ReadReply, error) {
+	if err := checkOpcode(payload, OpReadReply, 1); err != nil {
+		return ReadReply{}, err
+	}
+	return ReadReply{Data: payload[1:]}, nil
+}
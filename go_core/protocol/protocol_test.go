@@ -0,0 +1,143 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 封包以原本 adapter.go (SendCmd / SendAudioChunk / Connect) 寫入序列埠的位元組為準：
+// 0x25 | target | fid(2) | status(2) | len(2) | payload | checksum
+type wireFrame struct {
+	target  byte
+	payload []byte
+}
+
+func parseWire(t *testing.T, s string) wireFrame {
+	t.Helper()
+	raw, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad fixture %q: %v", s, err)
+	}
+	if len(raw) < 9 || raw[0] != 0x25 {
+		t.Fatalf("bad fixture %q", s)
+	}
+	n := int(raw[6]) | int(raw[7])<<8
+	if len(raw) != 8+n+1 {
+		t.Fatalf("fixture %q: length %d, header says %d", s, len(raw), n)
+	}
+	sum := 0
+	for _, b := range raw[1 : len(raw)-1] {
+		sum += int(b)
+	}
+	if byte(sum) != raw[len(raw)-1] {
+		t.Fatalf("fixture %q: checksum %02x, want %02x", s, raw[len(raw)-1], byte(sum))
+	}
+	return wireFrame{target: raw[1], payload: raw[8 : 8+n]}
+}
+
+func TestRequestEncoding(t *testing.T) {
+	mac, err := ParseMAC("AA:BB:CC:DD:EE:FF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		req    Request
+		wire   string
+		decode func([]byte) (Request, error)
+	}{
+		{"StopScan", StopScan{}, "25 24 01 00 00 00 02 00 83 00 aa",
+			func(p []byte) (Request, error) { return DecodeStopScan(p) }},
+		{"Connect", Connect{MAC: mac}, "25 24 02 00 00 00 07 00 85 ff ee dd cc bb aa ad",
+			func(p []byte) (Request, error) { return DecodeConnect(p) }},
+		{"ModeSwitch", ModeSwitch{Mode: ModePassthrough}, "25 21 03 00 00 00 01 00 01 26",
+			func(p []byte) (Request, error) { return DecodeModeSwitch(p) }},
+		{"Unlock", Unlock{}, "25 20 04 00 00 00 02 00 e6 01 0d",
+			func(p []byte) (Request, error) { return DecodeUnlock(p) }},
+		{"WriteAudio", WriteAudio{Offset: 608, Data: []byte{1, 2, 3, 4}}, "25 20 05 00 00 00 0b 00 c5 60 02 00 00 04 00 01 02 03 04 65",
+			func(p []byte) (Request, error) { return DecodeWriteAudio(p) }},
+		{"Read", Read{Offset: 0x1000, Size: 192}, "25 20 06 00 00 00 07 00 c6 00 10 00 00 c0 00 c3",
+			func(p []byte) (Request, error) { return DecodeRead(p) }},
+		{"Reboot", Reboot{}, "25 20 07 00 00 00 03 00 e4 00 01 0f",
+			func(p []byte) (Request, error) { return DecodeReboot(p) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := parseWire(t, tt.wire)
+			if got := tt.req.Target(); got != w.target {
+				t.Errorf("Target() = 0x%02X, want 0x%02X", got, w.target)
+			}
+			if got := tt.req.Encode(); !bytes.Equal(got, w.payload) {
+				t.Errorf("Encode() = % x, want % x", got, w.payload)
+			}
+			got, err := tt.decode(w.payload)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.req) {
+				t.Errorf("decode = %#v, want %#v", got, tt.req)
+			}
+		})
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	w := parseWire(t, "25 20 06 00 00 00 05 00 c7 11 22 33 44 9c")
+	r, err := DecodeReadReply(w.payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x11, 0x22, 0x33, 0x44}; !bytes.Equal(r.Data, want) {
+		t.Errorf("Data = % x, want % x", r.Data, want)
+	}
+	if got := r.Encode(); !bytes.Equal(got, w.payload) {
+		t.Errorf("Encode() = % x, want % x", got, w.payload)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		decode  func([]byte) error
+		payload []byte
+		short   bool
+	}{
+		{"StopScan short", func(p []byte) error { _, err := DecodeStopScan(p); return err }, []byte{0x83}, true},
+		{"Connect short", func(p []byte) error { _, err := DecodeConnect(p); return err }, []byte{0x85, 1, 2}, true},
+		{"Unlock wrong opcode", func(p []byte) error { _, err := DecodeUnlock(p); return err }, []byte{0xE4, 0x01}, false},
+		{"WriteAudio truncated data", func(p []byte) error { _, err := DecodeWriteAudio(p); return err }, []byte{0xC5, 0, 0, 0, 0, 4, 0, 1, 2}, true},
+		{"Read short", func(p []byte) error { _, err := DecodeRead(p); return err }, []byte{0xC6, 0, 0}, true},
+		{"ReadReply empty", func(p []byte) error { _, err := DecodeReadReply(p); return err }, nil, true},
+		{"ModeSwitch empty", func(p []byte) error { _, err := DecodeModeSwitch(p); return err }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.decode(tt.payload)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.short != errors.Is(err, ErrShortPayload) {
+				t.Errorf("err = %v, short = %v", err, tt.short)
+			}
+		})
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	want := [6]byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	for _, s := range []string{"AA:BB:CC:DD:EE:FF", "aabbccddeeff", " AA:BB:CC:DD:EE:FF "} {
+		got, err := ParseMAC(s)
+		if err != nil || got != want {
+			t.Errorf("ParseMAC(%q) = %x, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "AA:BB:CC", "GG:BB:CC:DD:EE:FF", "AA:BB:CC:DD:EE:FF:00"} {
+		if _, err := ParseMAC(s); err == nil {
+			t.Errorf("ParseMAC(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// ==========================================
+// 網路 Dongle (RFC 2217 Telnet COM Port Control)
+// 讓 "tcp://host:port" 的 Port 也能交給 SerialAdaptor 使用，包含 DTR/RTS 重置
+// ==========================================
+
+// TCPPortPrefix Order.Ports 中以此開頭的項目走 RFC 2217
+const TCPPortPrefix = "tcp://"
+
+// Telnet 指令
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptBinary  = 0
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+)
+
+// RFC 2217 Client → Server 子指令
+const (
+	comSetBaudRate = 1
+	comSetDataSize = 2
+	comSetParity   = 3
+	comSetStopSize = 4
+	comSetControl  = 5
+	comPurgeData   = 12
+
+	controlBreakOn  = 5
+	controlBreakOff = 6
+	controlDTROn    = 8
+	controlDTROff   = 9
+	controlRTSOn    = 11
+	controlRTSOff   = 12
+
+	purgeRX = 1
+	purgeTX = 2
+)
+
+func isTCPPort(port string) bool {
+	return strings.HasPrefix(strings.ToLower(port), TCPPortPrefix)
+}
+
+// openPort 依 Port 名稱開啟本機序列埠或 RFC 2217 網路序列埠
+func openPort(name string, mode *serial.Mode) (serial.Port, error) {
+	if isTCPPort(name) {
+		return OpenRFC2217(name[len(TCPPortPrefix):], mode)
+	}
+	return openSerial(name, mode)
+}
+
+// openSerial 開啟本機序列埠；只有 Linux pty Dongle 模擬器 (emulate-dongle 與測試) 會替換它
+var openSerial = serial.Open
+
+// RFC2217Port 實作 serial.Port
+type RFC2217Port struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	mu          sync.Mutex
+	rx          []byte
+	rxReady     chan struct{}
+	readTimeout time.Duration
+	err         error
+}
+
+// OpenRFC2217 連線至 host:port 並完成 COM-PORT-OPTION 協商
+func OpenRFC2217(addr string, mode *serial.Mode) (*RFC2217Port, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return newRFC2217Port(conn, mode)
+}
+
+// newRFC2217Port 在已建立的連線上協商 Telnet 選項並設定序列埠參數
+func newRFC2217Port(conn net.Conn, mode *serial.Mode) (*RFC2217Port, error) {
+	p := &RFC2217Port{
+		conn:        conn,
+		rxReady:     make(chan struct{}, 1),
+		readTimeout: serial.NoTimeout,
+	}
+	go p.readLoop()
+
+	p.sendRaw([]byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetWILL, telnetOptComPort,
+	})
+	if err := p.SetMode(mode); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// sendRaw 直接寫出 (不做 IAC 跳脫)
+func (p *RFC2217Port) sendRaw(b []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	_, err := p.conn.Write(b)
+	return err
+}
+
+// sendComPort 送出 IAC SB COM-PORT-OPTION <cmd> <value> IAC SE
+func (p *RFC2217Port) sendComPort(cmd byte, value []byte) error {
+	b := []byte{telnetIAC, telnetSB, telnetOptComPort, cmd}
+	for _, v := range value {
+		b = append(b, v)
+		if v == telnetIAC {
+			b = append(b, telnetIAC)
+		}
+	}
+	b = append(b, telnetIAC, telnetSE)
+	return p.sendRaw(b)
+}
+
+// readLoop 解析 Telnet 串流，將資料位元組放入 rx
+func (p *RFC2217Port) readLoop() {
+	const (
+		stData = iota
+		stIAC
+		stOption
+		stSB
+		stSBIAC
+	)
+	state := stData
+	var verb byte
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := p.conn.Read(buf)
+		if err != nil {
+			p.mu.Lock()
+			p.err = err
+			p.mu.Unlock()
+			p.signal()
+			return
+		}
+
+		var data []byte
+		for _, c := range buf[:n] {
+			switch state {
+			case stData:
+				if c == telnetIAC {
+					state = stIAC
+				} else {
+					data = append(data, c)
+				}
+			case stIAC:
+				switch c {
+				case telnetIAC:
+					data = append(data, c)
+					state = stData
+				case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+					verb = c
+					state = stOption
+				case telnetSB:
+					state = stSB
+				default:
+					state = stData
+				}
+			case stOption:
+				p.answerOption(verb, c)
+				state = stData
+			case stSB:
+				// 伺服器回報 (SET-* 確認、NOTIFY-MODEMSTATE 等) 目前不需要，略過內容
+				if c == telnetIAC {
+					state = stSBIAC
+				}
+			case stSBIAC:
+				if c == telnetSE {
+					state = stData
+				} else {
+					state = stSB
+				}
+			}
+		}
+
+		if len(data) > 0 {
+			p.mu.Lock()
+			p.rx = append(p.rx, data...)
+			p.mu.Unlock()
+			p.signal()
+		}
+	}
+}
+
+// answerOption 只接受 BINARY / SGA / COM-PORT-OPTION，其餘一律拒絕
+func (p *RFC2217Port) answerOption(verb, opt byte) {
+	supported := opt == telnetOptBinary || opt == telnetOptSGA || opt == telnetOptComPort
+	switch verb {
+	case telnetDO:
+		if !supported {
+			p.sendRaw([]byte{telnetIAC, telnetWONT, opt})
+		}
+	case telnetWILL:
+		if !supported {
+			p.sendRaw([]byte{telnetIAC, telnetDONT, opt})
+		}
+	}
+}
+
+func (p *RFC2217Port) signal() {
+	select {
+	case p.rxReady <- struct{}{}:
+	default:
+	}
+}
+
+func (p *RFC2217Port) SetMode(mode *serial.Mode) error {
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(mode.BaudRate))
+	if err := p.sendComPort(comSetBaudRate, baud); err != nil {
+		return err
+	}
+	dataBits := mode.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	if err := p.sendComPort(comSetDataSize, []byte{byte(dataBits)}); err != nil {
+		return err
+	}
+	// RFC 2217: 1=NONE 2=ODD 3=EVEN 4=MARK 5=SPACE
+	if err := p.sendComPort(comSetParity, []byte{byte(mode.Parity) + 1}); err != nil {
+		return err
+	}
+	// RFC 2217: 1=1 2=2 3=1.5
+	stop := byte(1)
+	switch mode.StopBits {
+	case serial.OnePointFiveStopBits:
+		stop = 3
+	case serial.TwoStopBits:
+		stop = 2
+	}
+	return p.sendComPort(comSetStopSize, []byte{stop})
+}
+
+func (p *RFC2217Port) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	timeout := p.readTimeout
+	p.mu.Unlock()
+
+	var deadline <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		p.mu.Lock()
+		if len(p.rx) > 0 {
+			n := copy(b, p.rx)
+			p.rx = p.rx[n:]
+			p.mu.Unlock()
+			return n, nil
+		}
+		err := p.err
+		p.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		select {
+		case <-p.rxReady:
+		case <-deadline:
+			return 0, nil
+		}
+	}
+}
+
+func (p *RFC2217Port) Write(b []byte) (int, error) {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		escaped = append(escaped, c)
+		if c == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+	}
+	if err := p.sendRaw(escaped); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *RFC2217Port) Drain() error {
+	return nil
+}
+
+func (p *RFC2217Port) ResetInputBuffer() error {
+	p.mu.Lock()
+	p.rx = p.rx[:0]
+	p.mu.Unlock()
+	return p.sendComPort(comPurgeData, []byte{purgeRX})
+}
+
+func (p *RFC2217Port) ResetOutputBuffer() error {
+	return p.sendComPort(comPurgeData, []byte{purgeTX})
+}
+
+func (p *RFC2217Port) SetDTR(dtr bool) error {
+	if dtr {
+		return p.sendComPort(comSetControl, []byte{controlDTROn})
+	}
+	return p.sendComPort(comSetControl, []byte{controlDTROff})
+}
+
+func (p *RFC2217Port) SetRTS(rts bool) error {
+	if rts {
+		return p.sendComPort(comSetControl, []byte{controlRTSOn})
+	}
+	return p.sendComPort(comSetControl, []byte{controlRTSOff})
+}
+
+func (p *RFC2217Port) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return nil, fmt.Errorf("rfc2217: modem status not supported")
+}
+
+func (p *RFC2217Port) SetReadTimeout(t time.Duration) error {
+	p.mu.Lock()
+	p.readTimeout = t
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *RFC2217Port) Close() error {
+	return p.conn.Close()
+}
+
+func (p *RFC2217Port) Break(d time.Duration) error {
+	if err := p.sendComPort(comSetControl, []byte{controlBreakOn}); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return p.sendComPort(comSetControl, []byte{controlBreakOff})
+}
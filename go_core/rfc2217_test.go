@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// telnetServer 在 net.Pipe 的另一端記錄 Client 送出的原始位元組
+type telnetServer struct {
+	conn net.Conn
+	mu   sync.Mutex
+	raw  []byte
+}
+
+func newTelnetServer(conn net.Conn) *telnetServer {
+	s := &telnetServer{conn: conn}
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			s.mu.Lock()
+			s.raw = append(s.raw, buf[:n]...)
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// take 等到收滿 n 個位元組後取出
+func (s *telnetServer) take(t *testing.T, n int) []byte {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.Lock()
+		if len(s.raw) >= n {
+			b := s.raw[:n]
+			s.raw = s.raw[n:]
+			s.mu.Unlock()
+			return b
+		}
+		got := len(s.raw)
+		s.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("server received %d bytes, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func comPort(cmd byte, value ...byte) []byte {
+	b := append([]byte{telnetIAC, telnetSB, telnetOptComPort, cmd}, value...)
+	return append(b, telnetIAC, telnetSE)
+}
+
+func TestRFC2217Loopback(t *testing.T) {
+	client, server := net.Pipe()
+	srv := newTelnetServer(server)
+	defer server.Close()
+
+	p, err := newRFC2217Port(client, &serial.Mode{BaudRate: 115200, StopBits: serial.TwoStopBits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// 選項協商與 SET-BAUDRATE / DATASIZE / PARITY / STOPSIZE
+	want := []byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetWILL, telnetOptComPort,
+	}
+	want = append(want, comPort(comSetBaudRate, 0x00, 0x01, 0xC2, 0x00)...)
+	want = append(want, comPort(comSetDataSize, 8)...)
+	want = append(want, comPort(comSetParity, 1)...)
+	want = append(want, comPort(comSetStopSize, 2)...)
+	if got := srv.take(t, len(want)); !bytes.Equal(got, want) {
+		t.Fatalf("negotiation\n got % X\nwant % X", got, want)
+	}
+
+	// 子協商內的 0xFF 也要跳脫 (65280 = 0x0000FF00)
+	if err := p.SetMode(&serial.Mode{BaudRate: 65280}); err != nil {
+		t.Fatal(err)
+	}
+	want = comPort(comSetBaudRate, 0x00, 0x00, 0xFF, 0xFF, 0x00)
+	if got := srv.take(t, len(want)); !bytes.Equal(got, want) {
+		t.Errorf("baud with IAC\n got % X\nwant % X", got, want)
+	}
+	srv.take(t, 3*len(comPort(0, 0))) // DATASIZE / PARITY / STOPSIZE
+
+	if err := p.SetDTR(false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.take(t, 7), comPort(comSetControl, controlDTROff); !bytes.Equal(got, want) {
+		t.Errorf("DTR off: % X, want % X", got, want)
+	}
+
+	// 資料中的 0xFF 寫出時加倍
+	if _, err := p.Write([]byte{0x25, 0xFF, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.take(t, 4), []byte{0x25, 0xFF, 0xFF, 0x01}; !bytes.Equal(got, want) {
+		t.Errorf("escaped write: % X, want % X", got, want)
+	}
+
+	// 伺服器端：IAC IAC 為資料；不支援的選項要拒絕；子協商回報略過
+	const optTerminalType = 24
+	incoming := []byte{0x10, telnetIAC, telnetIAC, telnetIAC, telnetDO, optTerminalType, 0x20}
+	incoming = append(incoming, comPort(comSetBaudRate+100, 0x00, 0x01, 0xC2, 0x00)...)
+	incoming = append(incoming, 0x30)
+	if _, err := server.Write(incoming); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.take(t, 3), []byte{telnetIAC, telnetWONT, optTerminalType}; !bytes.Equal(got, want) {
+		t.Errorf("option reply: % X, want % X", got, want)
+	}
+
+	p.SetReadTimeout(time.Second)
+	var data []byte
+	buf := make([]byte, 16)
+	for len(data) < 4 {
+		n, err := p.Read(buf)
+		if err != nil || n == 0 {
+			t.Fatalf("read: n=%d err=%v (got % X)", n, err, data)
+		}
+		data = append(data, buf[:n]...)
+	}
+	if want := []byte{0x10, 0xFF, 0x20, 0x30}; !bytes.Equal(data, want) {
+		t.Errorf("data % X, want % X", data, want)
+	}
+
+	p.SetReadTimeout(20 * time.Millisecond)
+	if n, err := p.Read(buf); n != 0 || err != nil {
+		t.Errorf("read timeout: n=%d err=%v", n, err)
+	}
+
+	server.Close()
+	p.SetReadTimeout(time.Second)
+	if _, err := p.Read(buf); err != io.EOF {
+		t.Errorf("read after close: %v, want EOF", err)
+	}
+}
+
+func TestIsTCPPort(t *testing.T) {
+	for name, want := range map[string]bool{
+		"tcp://10.0.0.5:4000": true,
+		"TCP://dongle:2217":   true,
+		"COM3":                false,
+		"/dev/ttyUSB0":        false,
+		"BLE:1":               false,
+		"tcp:/missing-slash":  false,
+	} {
+		if got := isTCPPort(name); got != want {
+			t.Errorf("isTCPPort(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// tcp:// 開頭的 Port 經由 openPort 連到 RFC 2217 伺服器 (前綴不分大小寫)
+func TestOpenPortTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("loopback unavailable: %v", err)
+	}
+	defer ln.Close()
+	first := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(first)
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 3)
+		io.ReadFull(conn, b)
+		first <- b
+		io.Copy(io.Discard, conn)
+	}()
+
+	port, err := openPort("TCP://"+ln.Addr().String(), &serial.Mode{BaudRate: 115200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+	if _, ok := port.(*RFC2217Port); !ok {
+		t.Fatalf("openPort returned %T, want *RFC2217Port", port)
+	}
+	if got := <-first; !bytes.Equal(got, []byte{telnetIAC, telnetWILL, telnetOptBinary}) {
+		t.Errorf("first bytes % X, want IAC WILL BINARY", got)
+	}
+}
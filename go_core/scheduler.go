@@ -0,0 +1,163 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// 作業排程
+// JobQueue 為優先佇列：從未失敗過的設備優先，失敗次數相同時依排入順序；
+// RELEASE 或 REBURN 後該 MAC 以指數退避 (含隨機抖動) 暫停，避免壞設備連續佔用 Dongle
+// ==========================================
+
+const (
+	backoffBase = 5 * time.Second
+	backoffMax  = 2 * time.Minute
+)
+
+// JobQueue 單一消費者 (Dispatcher) 的優先佇列，Push 不會阻塞
+type JobQueue struct {
+	mu    sync.Mutex
+	items jobHeap
+	seq   uint64
+	ready chan struct{}
+}
+
+func NewJobQueue() *JobQueue {
+	return &JobQueue{ready: make(chan struct{}, 1)}
+}
+
+type jobHeap []Job
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].Attempts != h[j].Attempts {
+		return h[i].Attempts < h[j].Attempts
+	}
+	return h[i].seq < h[j].seq
+}
+func (h jobHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x interface{}) { *h = append(*h, x.(Job)) }
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return job
+}
+
+// Push 排入作業；重新排入的作業保留原本的順序
+func (q *JobQueue) Push(job Job) {
+	q.mu.Lock()
+	if job.seq == 0 {
+		q.seq++
+		job.seq = q.seq
+	}
+	heap.Push(&q.items, job)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *JobQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Pop 取出最優先的作業，佇列為空時等待；ctx 結束時回傳 false
+func (q *JobQueue) Pop(ctx context.Context) (Job, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			job := heap.Pop(&q.items).(Job)
+			more := len(q.items) > 0
+			q.mu.Unlock()
+			if more {
+				q.signal()
+			}
+			return job, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return Job{}, false
+		}
+	}
+}
+
+// Exchange 若佇列中有比 job 更優先的作業，放回 job 並改取該作業
+// (Dispatcher 等待 Port 期間可能有新設備排入)
+func (q *JobQueue) Exchange(job Job) Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 || !(jobHeap{q.items[0], job}).Less(0, 1) {
+		return job
+	}
+	best := q.items[0]
+	q.items[0] = job
+	heap.Fix(&q.items, 0)
+	return best
+}
+
+func (q *JobQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// backoffDelay 第 n 次失敗後的等待時間：base·2^(n-1)，上限 backoffMax，
+// 取其一半再加上隨機的另一半，避免同時失敗的設備同時回來
+func backoffDelay(n int) time.Duration {
+	d := backoffBase
+	for i := 1; i < n && d < backoffMax; i++ {
+		d *= 2
+	}
+	if d > backoffMax {
+		d = backoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// scheduleRetry 失敗 (RELEASE / REBURN) 後設定該設備的下次重試時間，依累計失敗次數退避；
+// 呼叫端須持有 MapMutex
+func (m *FactoryManager) scheduleRetry(mac string) time.Duration {
+	b := m.budget(mac)
+	delay := backoffDelay(b.Reburns + b.Releases)
+	b.RetryAfter = time.Now().Add(delay)
+	return delay
+}
+
+// requeueAfter 退避期滿後將 REBURN 作業重新排入；等待期間 MAC 仍留在 ProcessingMap，
+// 掃描器不會重複排入。工作停止、操作員 CANCEL 或已 FAILED 時放棄並解除標記
+// 呼叫端須持有 MapMutex (只能在受 Stop 追蹤的 goroutine 中呼叫)
+func (m *FactoryManager) requeueAfter(job Job, delay time.Duration) {
+	m.spawn(func() {
+		err := sleepCtx(m.ctx, delay)
+		m.MapMutex.Lock()
+		defer m.MapMutex.Unlock()
+		if err != nil || m.CancelledMap[job.MAC] || m.isFailed(job.MAC) {
+			delete(m.ProcessingMap, job.MAC)
+			return
+		}
+		m.JobQueue.Push(job)
+	})
+}
+
+// inBackoff 呼叫端須持有 MapMutex
+func (m *FactoryManager) inBackoff(mac string) bool {
+	b := m.Budgets[m.progressKey(mac)]
+	return b != nil && time.Now().Before(b.RetryAfter)
+}
+
+// jobAttempts 先前失敗次數 (重燒 + 釋放)，作為排程優先順序；呼叫端須持有 MapMutex
+func (m *FactoryManager) jobAttempts(mac string) int {
+	if b := m.Budgets[m.progressKey(mac)]; b != nil {
+		return b.Reburns + b.Releases
+	}
+	return 0
+}
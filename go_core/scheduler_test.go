@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newSchedulerTestManager() *FactoryManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &FactoryManager{
+		ctx:           ctx,
+		cancel:        cancel,
+		JobQueue:      NewJobQueue(),
+		ProcessingMap: map[string]bool{testMAC: true},
+		CancelledMap:  make(map[string]bool),
+		Budgets:       make(map[ProgressKey]*DeviceBudget),
+	}
+}
+
+// REBURN 退避期間 MAC 仍為處理中，期滿才重新排入
+func TestReburnRequeueAfterBackoff(t *testing.T) {
+	m := newSchedulerTestManager()
+	defer m.cancel()
+
+	m.MapMutex.Lock()
+	m.budget(testMAC).Reburns = 1
+	if delay := m.scheduleRetry(testMAC); delay <= 0 || !m.inBackoff(testMAC) {
+		t.Fatalf("reburn should back off, delay %s", delay)
+	}
+	m.requeueAfter(Job{MAC: testMAC}, 50*time.Millisecond)
+	m.MapMutex.Unlock()
+
+	if n := m.JobQueue.Len(); n != 0 {
+		t.Fatalf("queued %d jobs before the backoff expired", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	job, ok := m.JobQueue.Pop(ctx)
+	if !ok || job.MAC != testMAC {
+		t.Fatalf("pop = %+v, %v", job, ok)
+	}
+	m.MapMutex.Lock()
+	defer m.MapMutex.Unlock()
+	if !m.ProcessingMap[testMAC] {
+		t.Error("MAC must stay in ProcessingMap while the reburn is queued")
+	}
+}
+
+// 退避中停工或取消：放棄排入並解除處理中標記，掃描器之後可重新排入
+func TestReburnRequeueAbandoned(t *testing.T) {
+	m := newSchedulerTestManager()
+	m.MapMutex.Lock()
+	m.requeueAfter(Job{MAC: testMAC}, time.Hour)
+	m.MapMutex.Unlock()
+	m.cancel()
+	m.routines.Wait()
+	if m.ProcessingMap[testMAC] || m.JobQueue.Len() != 0 {
+		t.Errorf("after stop: processing=%v queued=%d", m.ProcessingMap[testMAC], m.JobQueue.Len())
+	}
+
+	m = newSchedulerTestManager()
+	defer m.cancel()
+	m.CancelledMap[testMAC] = true
+	m.MapMutex.Lock()
+	m.requeueAfter(Job{MAC: testMAC}, time.Millisecond)
+	m.MapMutex.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.MapMutex.Lock()
+		processing := m.ProcessingMap[testMAC]
+		m.MapMutex.Unlock()
+		if !processing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cancelled reburn still marked as processing")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := m.JobQueue.Len(); n != 0 {
+		t.Errorf("cancelled reburn was queued (%d)", n)
+	}
+}
+
+// 失敗次數少的優先；次數相同依排入順序，重新排入的作業保留原本的順序
+func TestJobQueuePriority(t *testing.T) {
+	q := NewJobQueue()
+	q.Push(Job{MAC: "a", Attempts: 2})
+	q.Push(Job{MAC: "b", Attempts: 0})
+	q.Push(Job{MAC: "c", Attempts: 1})
+	q.Push(Job{MAC: "d", Attempts: 0})
+
+	ctx := context.Background()
+	first, _ := q.Pop(ctx)
+	if first.MAC != "b" {
+		t.Fatalf("first = %s, want b", first.MAC)
+	}
+	q.Push(Job{MAC: "e", Attempts: 0})
+	q.Push(first) // 重新排入，仍排在 d、e 之前
+
+	var got []string
+	for q.Len() > 0 {
+		job, _ := q.Pop(ctx)
+		got = append(got, job.MAC)
+	}
+	if want := []string{"b", "d", "e", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order %v, want %v", got, want)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, ok := q.Pop(cancelled); ok {
+		t.Error("Pop on an empty queue returned a job after cancel")
+	}
+}
+
+// Dispatcher 等待 Port 期間排入更優先的作業時會換手；否則保留手上的作業
+func TestJobQueueExchange(t *testing.T) {
+	q := NewJobQueue()
+	if got := q.Exchange(Job{MAC: "held", Attempts: 1}); got.MAC != "held" {
+		t.Errorf("empty queue: got %s", got.MAC)
+	}
+
+	q.Push(Job{MAC: "held", Attempts: 1})
+	held, _ := q.Pop(context.Background())
+	q.Push(Job{MAC: "worse", Attempts: 3})
+	if got := q.Exchange(held); got.MAC != "held" {
+		t.Errorf("lower priority waiting: got %s", got.MAC)
+	}
+	q.Push(Job{MAC: "same", Attempts: 1}) // 次數相同但較晚排入
+	if got := q.Exchange(held); got.MAC != "held" {
+		t.Errorf("same attempts, later seq: got %s", got.MAC)
+	}
+
+	q.Push(Job{MAC: "fresh", Attempts: 0})
+	if got := q.Exchange(held); got.MAC != "fresh" {
+		t.Fatalf("better job waiting: got %s", got.MAC)
+	}
+	var rest []string
+	for q.Len() > 0 {
+		job, _ := q.Pop(context.Background())
+		rest = append(rest, job.MAC)
+	}
+	if want := []string{"held", "same", "worse"}; !reflect.DeepEqual(rest, want) {
+		t.Errorf("queue after exchange %v, want %v", rest, want)
+	}
+}
+
+// 退避時間逐次加倍，上限 backoffMax；抖動只落在後半段
+func TestBackoffDelay(t *testing.T) {
+	for n := 1; n <= 12; n++ {
+		want := backoffBase << (n - 1)
+		if want > backoffMax {
+			want = backoffMax
+		}
+		for i := 0; i < 50; i++ {
+			if d := backoffDelay(n); d < want/2 || d > want {
+				t.Fatalf("backoffDelay(%d) = %s, want within [%s, %s]", n, d, want/2, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"BM2/protocol"
+)
+
+// ==========================================
+// 通訊紀錄 (Trace) 錄製與重播
+// 每行一筆 JSON，TX/RX 為原始收發的位元組 (hex)
+// 支援 rawTapper 的傳輸 (Dongle / 主機藍牙) 記錄實際寫入與讀到的資料，
+// 包含 Connect 過程、雜訊與 Checksum 錯誤的位元組；其他傳輸退回記錄解碼後的封包
+// ==========================================
+
+const (
+	TraceConnect    = "CONNECT"
+	TraceDisconnect = "DISCONNECT"
+	TraceTX         = "TX"
+	TraceRX         = "RX"
+	TraceReset      = "RESET"
+)
+
+// TraceEvent 單筆通訊紀錄
+type TraceEvent struct {
+	Time  time.Time `json:"time"`
+	Port  string    `json:"port"`
+	MAC   string    `json:"mac,omitempty"`
+	Dir   string    `json:"dir"`
+	Data  string    `json:"data,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// rawTapper 可回報原始收發位元組的傳輸
+type rawTapper interface {
+	SetTap(tap func(dir string, data []byte))
+}
+
+// TraceRecorder 包裝任意 Transporter，將所有收發寫入 Trace 檔
+type TraceRecorder struct {
+	Inner Transporter
+	Port  string
+	MAC   string
+	Path  string
+
+	raw bool // Inner 直接回報原始位元組
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewTraceRecorder 在 dir 下建立 <時間>_<port>_<mac>.trace.jsonl
+func NewTraceRecorder(inner Transporter, dir, port, mac string) (*TraceRecorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	clean := strings.NewReplacer(":", "", "/", "_", "\\", "_").Replace
+	name := fmt.Sprintf("%s_%s_%s.trace.jsonl", time.Now().Format("20060102-150405.000"), clean(port), clean(mac))
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &TraceRecorder{Inner: inner, Port: port, MAC: mac, Path: path, f: f, enc: json.NewEncoder(f)}
+	if t, ok := inner.(rawTapper); ok {
+		t.SetTap(func(dir string, data []byte) { r.record(dir, data, nil) })
+		r.raw = true
+	}
+	return r, nil
+}
+
+func (r *TraceRecorder) record(dir string, data []byte, err error) {
+	ev := TraceEvent{Time: time.Now(), Port: r.Port, MAC: r.MAC, Dir: dir}
+	if len(data) > 0 {
+		ev.Data = hex.EncodeToString(data)
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.enc != nil {
+		r.enc.Encode(ev)
+	}
+}
+
+func (r *TraceRecorder) Close() error {
+	if t, ok := r.Inner.(rawTapper); ok && r.raw {
+		t.SetTap(nil)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc = nil
+	return r.f.Close()
+}
+
+func (r *TraceRecorder) Connect(ctx context.Context, mac string) error {
+	r.MAC = mac
+	err := r.Inner.Connect(ctx, mac)
+	r.record(TraceConnect, nil, err)
+	return err
+}
+
+func (r *TraceRecorder) Disconnect() error {
+	err := r.Inner.Disconnect()
+	r.record(TraceDisconnect, nil, err)
+	return err
+}
+
+func (r *TraceRecorder) SendCmd(target byte, payload []byte) (uint16, error) {
+	fid, err := r.Inner.SendCmd(target, payload)
+	if !r.raw || err != nil {
+		r.record(TraceTX, buildFrame(target, fid, 0, payload), err)
+	}
+	return fid, err
+}
+
+func (r *TraceRecorder) SendAudioChunk(offset int, data []byte) (uint16, error) {
+	return SendRequest(r, protocol.WriteAudio{Offset: uint32(offset), Data: data})
+}
+
+func (r *TraceRecorder) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	f, err := r.Inner.ReadFrame(ctx, timeout)
+	if err != nil {
+		r.record(TraceRX, nil, err)
+		return f, err
+	}
+	if !r.raw {
+		r.record(TraceRX, buildFrame(f.Target, f.FID, f.Status, f.Payload), nil)
+	}
+	return f, nil
+}
+
+func (r *TraceRecorder) ResetBuffer() {
+	r.Inner.ResetBuffer()
+	r.record(TraceReset, nil, nil)
+}
+
+// WaitForACK 透過自己的 ReadFrame 等待，確保被丟棄的封包也會被記錄
+func (r *TraceRecorder) WaitForACK(ctx context.Context, fid uint16, timeout time.Duration) error {
+	return waitForACK(ctx, r, fid, timeout)
+}
+
+// LoadTrace 讀取 Trace 檔
+func LoadTrace(path string) ([]TraceEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []TraceEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var ev TraceEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("trace: %v", err)
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// ReplayTransport 依序重播 Trace 中的回應，不等待真實時間
+// 送出的封包與紀錄不符時記入 Divergences，方便轉成回歸測試
+type ReplayTransport struct {
+	Events      []TraceEvent
+	Divergences []string
+
+	pos int
+	fid uint16
+	dec FrameDecoder // RX 紀錄可能是任意切段的原始位元組
+}
+
+func NewReplayTransport(events []TraceEvent) *ReplayTransport {
+	return &ReplayTransport{Events: events}
+}
+
+// next 取出下一筆方向為 dir 的紀錄；順序不符時回傳 nil
+func (p *ReplayTransport) next(dir string) *TraceEvent {
+	if p.pos >= len(p.Events) {
+		p.diverge("%s: trace 已結束", dir)
+		return nil
+	}
+	ev := &p.Events[p.pos]
+	if ev.Dir != dir {
+		p.diverge("#%d: 預期 %s，紀錄為 %s", p.pos, dir, ev.Dir)
+		return nil
+	}
+	p.pos++
+	return ev
+}
+
+func (p *ReplayTransport) diverge(format string, a ...interface{}) {
+	p.Divergences = append(p.Divergences, fmt.Sprintf(format, a...))
+}
+
+func eventError(ev *TraceEvent) error {
+	switch ev.Error {
+	case "":
+		return nil
+	case ErrACKTimeout.Error():
+		return ErrACKTimeout
+	}
+	return fmt.Errorf("%s", ev.Error)
+}
+
+// Done 回傳是否已重播完所有紀錄
+func (p *ReplayTransport) Done() bool {
+	return p.pos >= len(p.Events)
+}
+
+// Connect 原始紀錄中 CONNECT 之前的收發屬於連線流程本身，重播時略過
+func (p *ReplayTransport) Connect(ctx context.Context, mac string) error {
+	for p.pos < len(p.Events) && p.Events[p.pos].Dir != TraceConnect && p.Events[p.pos].Dir != TraceDisconnect {
+		p.pos++
+	}
+	p.dec.Reset()
+	ev := p.next(TraceConnect)
+	if ev == nil {
+		return fmt.Errorf("replay: unexpected connect")
+	}
+	return eventError(ev)
+}
+
+func (p *ReplayTransport) Disconnect() error {
+	if p.pos < len(p.Events) && p.Events[p.pos].Dir == TraceDisconnect {
+		p.pos++
+	}
+	return nil
+}
+
+func (p *ReplayTransport) SendCmd(target byte, payload []byte) (uint16, error) {
+	ev := p.next(TraceTX)
+	if ev == nil {
+		p.fid++
+		return p.fid, nil
+	}
+	recorded, err := hex.DecodeString(ev.Data)
+	if err != nil || len(recorded) < frameHeaderSize {
+		p.diverge("#%d: TX 紀錄無法解析", p.pos-1)
+		p.fid++
+		return p.fid, eventError(ev)
+	}
+	p.fid = uint16(recorded[2]) | uint16(recorded[3])<<8
+	if sent := buildFrame(target, p.fid, 0, payload); !bytes.Equal(sent, recorded) {
+		p.diverge("#%d: TX 內容不同 (sent %s, trace %s)", p.pos-1, hex.EncodeToString(safeSlice(sent, 16)), hex.EncodeToString(safeSlice(recorded, 16)))
+	}
+	return p.fid, eventError(ev)
+}
+
+func (p *ReplayTransport) SendAudioChunk(offset int, data []byte) (uint16, error) {
+	return SendRequest(p, protocol.WriteAudio{Offset: uint32(offset), Data: data})
+}
+
+func (p *ReplayTransport) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return Frame{}, err
+	}
+	for {
+		if f, ok := p.dec.Next(); ok {
+			return f, nil
+		}
+		if p.pos >= len(p.Events) || p.Events[p.pos].Dir != TraceRX {
+			return Frame{}, ErrACKTimeout
+		}
+		ev := &p.Events[p.pos]
+		p.pos++
+		if ev.Error != "" {
+			return Frame{}, eventError(ev)
+		}
+		raw, err := hex.DecodeString(ev.Data)
+		if err != nil {
+			return Frame{}, fmt.Errorf("replay: %v", err)
+		}
+		p.dec.Feed(raw)
+	}
+}
+
+func (p *ReplayTransport) ResetBuffer() {
+	p.dec.Reset()
+	if p.pos < len(p.Events) && p.Events[p.pos].Dir == TraceReset {
+		p.pos++
+	}
+}
+
+func (p *ReplayTransport) WaitForACK(ctx context.Context, fid uint16, timeout time.Duration) error {
+	return waitForACK(ctx, p, fid, timeout)
+}
@@ -2,17 +2,26 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
+
+	"BM2/protocol"
 )
 
+// verifySettleTime 比對前等待連線穩定的時間 (測試時縮短)
+var verifySettleTime = 10 * time.Second
+
 // PerformFinalDebugCheck 執行最終的一致性比對
-func PerformFinalDebugCheck(t Transporter, meta FileMeta, prefix string) (bool, error) {
+func PerformFinalDebugCheck(ctx context.Context, t Transporter, meta FileMeta, prefix string) (bool, error) {
 	reportLog("%s ⚖️  === 正在啟動語音一致性比對 ===", prefix)
 
 	reportLog("%s ⏳ 正在緩衝連線，等待 10 秒...", prefix)
-	time.Sleep(10 * time.Second)
+	if err := sleepCtx(ctx, verifySettleTime); err != nil {
+		return false, err
+	}
 
 	// 1. 顯示本地檔案資訊
 	if len(meta.RawData) < 606 {
@@ -22,7 +31,7 @@ func PerformFinalDebugCheck(t Transporter, meta FileMeta, prefix string) (bool,
 
 	// 解鎖設備
 	reportLog("%s  正在解鎖設備 (Set Engineering Mode)...", prefix)
-	if !unlockDevice(t, prefix) {
+	if !unlockDevice(ctx, t, prefix) {
 		reportLog("%s ❌ 讀取設備失敗，無法讀取語音", prefix)
 		return false, fmt.Errorf("解鎖失敗") // 這裡回傳 error，main.go 會執行 RELEASE
 	}
@@ -30,7 +39,10 @@ func PerformFinalDebugCheck(t Transporter, meta FileMeta, prefix string) (bool,
 	// 2. 讀取設備資訊
 	reportLog("%s 📥 === 正在讀取資料 (分頁讀取) ===", prefix)
 
-	deviceTracks := performPagedRead(t, prefix)
+	deviceTracks := performPagedRead(ctx, t, prefix)
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 
 	// 🔥 優化 1：如果讀取不到資料 (nil) 或資料是空的 (empty)，視為讀取失敗
 	// 這樣 main.go 會執行 STATUS_RELEASE (換 Dongle)，而不是 STATUS_REBURN
@@ -45,25 +57,28 @@ func PerformFinalDebugCheck(t Transporter, meta FileMeta, prefix string) (bool,
 }
 
 // unlockDevice (保持不變)
-func unlockDevice(t Transporter, prefix string) bool {
-	var f uint16 = 0
+func unlockDevice(ctx context.Context, t Transporter, prefix string) bool {
 	for i := 0; i < 3; i++ {
 		t.ResetBuffer()
-		t.SendCmd(0x20, &f, []byte{0xE6, 0x01})
-		if err := t.WaitForACK(2 * time.Second); err == nil {
+		fid, _ := SendRequest(t, protocol.Unlock{})
+		if err := t.WaitForACK(ctx, fid, 2*time.Second); err == nil {
 			return true
 		}
+		if ctx.Err() != nil {
+			return false
+		}
 		// 建議改為：每次失敗都等一秒，給設備喘息機會
 		reportLog("%s 嘗試讀取語音失敗 %d/3 ，等待 2s...", prefix, i+1)
-		time.Sleep(2 * time.Second)
+		if sleepCtx(ctx, 2*time.Second) != nil {
+			return false
+		}
 	}
 	return false
 }
 
-// performPagedRead (保持不變)
-func performPagedRead(t Transporter, prefix string) map[int]TrackInfo {
+// performPagedRead 分頁讀取設備 Header；連線中斷時回傳 nil
+func performPagedRead(ctx context.Context, t Transporter, prefix string) map[int]TrackInfo {
 	payloadBuffer := make([]byte, 0, 1024)
-	rawBuffer := make([]byte, 0, 4096)
 	magicCode := []byte{0x27, 0x9D}
 	targetSize := 606
 	chunkSize := 192
@@ -71,6 +86,9 @@ func performPagedRead(t Transporter, prefix string) map[int]TrackInfo {
 	currentOffset := 0
 
 	for len(payloadBuffer) < targetSize {
+		if ctx.Err() != nil {
+			return nil
+		}
 		if time.Now().After(totalDeadline) {
 			reportLog("%s ❌ 讀取總時長超時", prefix)
 			break
@@ -81,54 +99,45 @@ func performPagedRead(t Transporter, prefix string) map[int]TrackInfo {
 			reqSize = needed
 		}
 
-		sendReadCommand(t, currentOffset, reqSize)
+		fid, err := sendReadCommand(t, currentOffset, reqSize)
+		if err != nil {
+			reportLog("%s ❌ 讀取指令送出失敗: %v", prefix, err)
+			return nil
+		}
 		chunkDeadline := time.Now().Add(2500 * time.Millisecond)
 		chunkReceived := false
 
-		for time.Now().Before(chunkDeadline) {
-			chunk, err := t.ReadResponse(50 * time.Millisecond)
-			if err == nil && len(chunk) > 0 {
-				rawBuffer = append(rawBuffer, chunk...)
-				for len(rawBuffer) > 8 {
-					startIdx := bytes.IndexByte(rawBuffer, 0x25)
-					if startIdx == -1 {
-						if len(rawBuffer) > 5 {
-							rawBuffer = rawBuffer[len(rawBuffer)-5:]
-						}
-						break
-					}
-					if startIdx > 0 {
-						rawBuffer = rawBuffer[startIdx:]
-					}
-					if len(rawBuffer) < 8 {
-						break
-					}
-
-					payloadLen := int(rawBuffer[6]) | (int(rawBuffer[7]) << 8)
-					packetLen := 8 + payloadLen + 1
-					if len(rawBuffer) < packetLen {
-						break
-					}
-
-					payload := rawBuffer[8 : 8+payloadLen]
-					if len(payload) > 0 && payload[0] == 0xC7 {
-						realData := payload[1:]
-						payloadBuffer = append(payloadBuffer, realData...)
-						currentOffset += len(realData)
-						chunkReceived = true
-					}
-					rawBuffer = rawBuffer[packetLen:]
-				}
-				if chunkReceived {
-					break
+		for !chunkReceived {
+			remaining := time.Until(chunkDeadline)
+			if remaining <= 0 {
+				break
+			}
+			frame, err := t.ReadFrame(ctx, remaining)
+			if errors.Is(err, ErrACKTimeout) {
+				break
+			}
+			if err != nil {
+				// Port 關閉或連線中斷：重送也收不到，不必等到總時長超時
+				reportLog("%s ❌ 讀取中斷: %v", prefix, err)
+				return nil
+			}
+			// 只接受這次讀取的回覆；前一次逾時後才到的回覆 Offset 不對
+			if frame.Kind == FrameData && frame.FID == fid {
+				reply, err := protocol.DecodeReadReply(frame.Payload)
+				if err != nil {
+					continue
 				}
+				realData := reply.Data
+				payloadBuffer = append(payloadBuffer, realData...)
+				currentOffset += len(realData)
+				chunkReceived = true
 			}
 		}
 
 		if !chunkReceived {
 			reportLog("%s ⚠️ 讀取超時，重試 Offset: %d...", prefix, currentOffset)
 		} else {
-			time.Sleep(100 * time.Millisecond)
+			sleepCtx(ctx, 100*time.Millisecond)
 		}
 	}
 
@@ -152,14 +161,9 @@ func safeSlice(b []byte, n int) []byte {
 	return b
 }
 
-func sendReadCommand(t Transporter, offset int, size int) {
+func sendReadCommand(t Transporter, offset int, size int) (uint16, error) {
 	t.ResetBuffer()
-	var f uint16 = 0
-	readCmd := make([]byte, 0, 7)
-	readCmd = append(readCmd, 0xC6)
-	readCmd = append(readCmd, byte(offset&0xff), byte((offset>>8)&0xff), byte((offset>>16)&0xff), byte((offset>>24)&0xff))
-	readCmd = append(readCmd, byte(size&0xff), byte((size>>8)&0xff))
-	t.SendCmd(0x20, &f, readCmd)
+	return SendRequest(t, protocol.Read{Offset: uint32(offset), Size: uint16(size)})
 }
 
 // performComparisonModular 執行比對並輸出 Flutter 可解析的 Log
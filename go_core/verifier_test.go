@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"BM2/protocol"
+)
+
+// lateReplyTransport 每個讀取指令前先送出上一個 FID 的遲到回覆 (內容錯誤)，再送出正確回覆
+type lateReplyTransport struct {
+	Transporter
+	image   []byte
+	fid     uint16
+	pending []Frame
+	readErr error
+}
+
+func (l *lateReplyTransport) SendCmd(target byte, payload []byte) (uint16, error) {
+	l.fid++
+	req, err := protocol.DecodeRead(payload)
+	if err != nil {
+		return l.fid, nil
+	}
+	end := min(int(req.Offset)+int(req.Size), len(l.image))
+	late := protocol.ReadReply{Data: make([]byte, req.Size)}
+	for i := range late.Data {
+		late.Data[i] = 0xAA
+	}
+	l.pending = append(l.pending,
+		Frame{Kind: FrameData, FID: l.fid - 1, Payload: late.Encode()},
+		Frame{Kind: FrameData, FID: l.fid, Payload: protocol.ReadReply{Data: l.image[req.Offset:end]}.Encode()},
+	)
+	return l.fid, nil
+}
+
+func (l *lateReplyTransport) ReadFrame(ctx context.Context, timeout time.Duration) (Frame, error) {
+	if l.readErr != nil {
+		return Frame{}, l.readErr
+	}
+	if len(l.pending) == 0 {
+		return Frame{}, ErrACKTimeout
+	}
+	f := l.pending[0]
+	l.pending = l.pending[1:]
+	return f, nil
+}
+
+func (l *lateReplyTransport) ResetBuffer() {}
+
+// 前一個讀取的遲到回覆不能被當成這次的資料
+func TestPagedReadIgnoresOtherFIDs(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 1000, 2, 3000, 7, 500))
+	_, want := parseHeaderBytes(meta.RawData[:606], "Local ADS", "[TEST]")
+
+	got := performPagedRead(context.Background(), &lateReplyTransport{image: meta.RawData}, "[TEST]")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tracks = %v, want %v", got, want)
+	}
+}
+
+// 連線中斷時立即放棄，不等到 25 秒總時長
+func TestPagedReadStopsOnLinkError(t *testing.T) {
+	meta := ParseADSBytes(syntheticADS(1, 1000))
+	tr := &lateReplyTransport{image: meta.RawData, readErr: errors.Join(ErrDongleFault, io.ErrClosedPipe)}
+
+	start := time.Now()
+	if got := performPagedRead(context.Background(), tr, "[TEST]"); got != nil {
+		t.Errorf("tracks = %v after link error, want nil", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("paged read took %s after link error", elapsed)
+	}
+}